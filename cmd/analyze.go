@@ -1,14 +1,18 @@
 package cmd
 
 import (
-    "bufio"
-    "encoding/json"
+    "compress/gzip"
     "fmt"
+    "io"
     "log"
     "os"
+    "path/filepath"
+    "sort"
+    "strings"
 
     "github.com/spf13/cobra"
     "github.com/syedowais312/chaos-cli/pkg/analyze"
+    "github.com/syedowais312/chaos-cli/pkg/metrics"
     "github.com/syedowais312/chaos-cli/pkg/utils"
 )
 
@@ -102,28 +106,86 @@ func runAnalyze(cmd *cobra.Command, args []string) {
     }
 }
 
-// loadMetrics loads metrics from an NDJSON file
-func loadMetrics(filename string) ([]analyze.RequestMetric, error) {
-    file, err := os.Open(filename)
+// loadMetrics loads metrics from an NDJSON file, a glob or a directory of
+// rotated segments (plain or .gz), skipping event lines such as rules reloads.
+// Files of older schema versions are upgraded; newer or foreign ones fail.
+func loadMetrics(pattern string) ([]analyze.RequestMetric, error) {
+    files, err := metricFiles(pattern)
     if err != nil {
         return nil, err
     }
-    defer file.Close()
 
-    var metrics []analyze.RequestMetric
-    scanner := bufio.NewScanner(file)
+    var all []analyze.RequestMetric
+    for _, name := range files {
+        m, err := loadMetricsFile(name)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", name, err)
+        }
+        all = append(all, m...)
+    }
+    return all, nil
+}
 
-    for scanner.Scan() {
-        var metric analyze.RequestMetric
-        if err := json.Unmarshal(scanner.Bytes(), &metric); err != nil {
-            return nil, fmt.Errorf("failed to parse line: %w", err)
+// metricFiles expands pattern into files in time order: a file's rotated
+// segments oldest first and then the file itself, or the files of a
+// directory (one run's output) or glob in name order, which is time order
+// for segments (the active file sorts last)
+func metricFiles(pattern string) ([]string, error) {
+    if info, err := os.Stat(pattern); err == nil {
+        if !info.IsDir() {
+            // an --output file: its rotated segments come first, the
+            // active file is the newest part of the run
+            files, err := metrics.Segments(pattern)
+            if err != nil {
+                return nil, err
+            }
+            return append(files, pattern), nil
         }
-        metrics = append(metrics, metric)
+        var files []string
+        for _, glob := range []string{"*.ndjson", "*.ndjson.gz"} {
+            m, err := filepath.Glob(filepath.Join(pattern, glob))
+            if err != nil {
+                return nil, err
+            }
+            files = append(files, m...)
+        }
+        if len(files) == 0 {
+            return nil, fmt.Errorf("no .ndjson or .ndjson.gz files in %s", pattern)
+        }
+        sort.Strings(files)
+        return files, nil
     }
 
-    if err := scanner.Err(); err != nil {
+    files, err := filepath.Glob(pattern)
+    if err != nil {
         return nil, err
     }
+    if len(files) == 0 {
+        // report the missing file the same way a plain open would
+        _, err := os.Stat(pattern)
+        return nil, err
+    }
+    return files, nil
+}
 
-    return metrics, nil
-}
\ No newline at end of file
+// loadMetricsFile reads one NDJSON file, decompressing .gz files, and checks
+// its schema version
+func loadMetricsFile(filename string) ([]analyze.RequestMetric, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var r io.Reader = file
+    if strings.HasSuffix(filename, ".gz") {
+        zr, err := gzip.NewReader(file)
+        if err != nil {
+            return nil, err
+        }
+        defer zr.Close()
+        r = zr
+    }
+
+    return metrics.ReadRequests(r)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMetricFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"experiment.ndjson",
+		"experiment-20250101T130000.000Z.ndjson.gz",
+		"experiment-20250101T120000.000Z.ndjson.gz",
+		"experiment-20250101T120000.000Z-1.ndjson",
+		"baseline.ndjson",
+		"notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"baseline.ndjson", []string{"baseline.ndjson"}},
+		// a file brings its rotated segments, oldest first
+		{"experiment.ndjson", []string{
+			"experiment-20250101T120000.000Z.ndjson.gz",
+			"experiment-20250101T120000.000Z-1.ndjson",
+			"experiment-20250101T130000.000Z.ndjson.gz",
+			"experiment.ndjson",
+		}},
+		{"experiment-20250101T130000.000Z.ndjson.gz", []string{"experiment-20250101T130000.000Z.ndjson.gz"}},
+		{"experiment-*.ndjson*", []string{
+			"experiment-20250101T120000.000Z-1.ndjson",
+			"experiment-20250101T120000.000Z.ndjson.gz",
+			"experiment-20250101T130000.000Z.ndjson.gz",
+		}},
+		// a directory is read in name order, the active file last
+		{"", []string{
+			"baseline.ndjson",
+			"experiment-20250101T120000.000Z-1.ndjson",
+			"experiment-20250101T120000.000Z.ndjson.gz",
+			"experiment-20250101T130000.000Z.ndjson.gz",
+			"experiment.ndjson",
+		}},
+	}
+	for _, tt := range tests {
+		files, err := metricFiles(filepath.Join(dir, tt.pattern))
+		if err != nil {
+			t.Errorf("metricFiles(%q): %v", tt.pattern, err)
+			continue
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, filepath.Base(f))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("metricFiles(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"missing.ndjson", "missing-*.ndjson"} {
+		if _, err := metricFiles(filepath.Join(dir, pattern)); err == nil {
+			t.Errorf("metricFiles(%q): expected an error", pattern)
+		}
+	}
+	empty := t.TempDir()
+	if _, err := metricFiles(empty); err == nil {
+		t.Error("metricFiles of an empty directory: expected an error")
+	}
+}
@@ -6,10 +6,13 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/syedowais312/chaos-cli/pkg/metrics"
 	"github.com/syedowais312/chaos-cli/pkg/proxy"
 	"github.com/syedowais312/chaos-cli/pkg/utils"
 
@@ -29,6 +32,16 @@ var (
 	failureRate float64
 	rulePath    string
 	ruleMethod  string
+	rulesFile   string
+	adminPort   int
+	adminAddr   string
+	metricsPort int
+	watchEvery  time.Duration
+	seed        int64
+	flushEvery  time.Duration
+	rotateSize  string
+	rotateEvery time.Duration
+	rotateGzip  bool
 )
 
 // httpCmd represents the http command
@@ -38,6 +51,7 @@ var httpCmd = &cobra.Command{
 	Long: `HTTP subcommands for the chaos-tool. Examples:
 
   chaos-tool http proxy --target http://localhost:3000 --path /login --method POST --delay 2s --failure-rate 0.2
+  chaos-tool http proxy --target http://localhost:3000 --rules rules.yaml
 `,
 }
 
@@ -49,9 +63,19 @@ func init() {
 	httpProxyCmd.Flags().IntVar(&port, "port", 8080, "Port to run the chaos proxy on")
 	httpProxyCmd.Flags().DurationVar(&delay, "delay", 0, "Delay to inject")
 	httpProxyCmd.Flags().Float64Var(&failureRate, "failure-rate", 0.0, "Failure rate (0.0 - 1.0)")
-	httpProxyCmd.Flags().StringVar(&rulePath, "path", "/", "API path to match")
+	httpProxyCmd.Flags().StringVar(&rulePath, "path", "", "API path pattern to match (empty matches every path)")
 	httpProxyCmd.Flags().StringVar(&ruleMethod, "method", "", "HTTP method (GET, POST, etc.)")
+	httpProxyCmd.Flags().StringVar(&rulesFile, "rules", "", "YAML or JSON file with chaos rules (replaces --path/--method/--delay/--failure-rate)")
+	httpProxyCmd.Flags().DurationVar(&watchEvery, "watch-interval", 2*time.Second, "How often to check --rules for changes (0 disables polling; SIGHUP always reloads)")
+	httpProxyCmd.Flags().IntVar(&adminPort, "admin-port", 0, "Port for the rules admin API (0 disables it)")
+	httpProxyCmd.Flags().StringVar(&adminAddr, "admin-addr", "127.0.0.1", "Interface for the rules admin API; it has no authentication, so expose it (e.g. 0.0.0.0) only on trusted networks")
+	httpProxyCmd.Flags().IntVar(&metricsPort, "metrics-port", 0, "Port for live Prometheus metrics at /metrics (0 disables it)")
+	httpProxyCmd.Flags().Int64Var(&seed, "seed", 0, "Seed for chaos randomness; reuse a printed seed to replay the same fault pattern (random if unset)")
 	httpProxyCmd.Flags().DurationVar(&duration, "duration", 0, "Duration to run proxy (e.g., 60s). 0 means run until Ctrl+C")
+	httpProxyCmd.Flags().DurationVar(&flushEvery, "flush-interval", time.Second, "How often streamed metrics are flushed to --output")
+	httpProxyCmd.Flags().StringVar(&rotateSize, "rotate-size", "", "Rotate --output once it reaches this size (e.g. 100MB); empty disables")
+	httpProxyCmd.Flags().DurationVar(&rotateEvery, "rotate-every", 0, "Rotate --output this often (e.g. 1h); 0 disables")
+	httpProxyCmd.Flags().BoolVar(&rotateGzip, "rotate-gzip", false, "Gzip rotated metrics segments")
     // Default metrics filename will be resolved into chaos-cli-test folder
     httpProxyCmd.Flags().StringVar(&output, "output", "baseline.ndjson", "NDJSON metrics filename (default: chaos-cli-test/baseline.ndjson)")
 }
@@ -66,17 +90,15 @@ var httpProxyCmd = &cobra.Command{
             method = "" // empty means match any method (depends on your matching logic)
         }
 
-		// Build rules from flags (you can extend to accept multiple rules)
-		rules := []proxy.ChaosRule{
-			{
-				Path:        rulePath,
-				Method:      method,
-				Delay:       delay,
-				FailureRate: failureRate,
-				// It's probably better to make status and body flags; using defaults here
-				StatusCode: 503,
-				ErrorBody:  `{"error":"chaos injected"}`,
-			},
+		rules, err := buildRules(cmd, method)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		rotateBytes, err := parseSize(rotateSize)
+		if err != nil {
+			fmt.Println("error: --rotate-size:", err)
+			return
 		}
 
 		p, err := proxy.NewChaosProxy(target, port, rules)
@@ -84,11 +106,18 @@ var httpProxyCmd = &cobra.Command{
 			fmt.Println("error:", err)
 			return
 		}
+		p.AdminPort = adminPort
+		p.AdminAddr = adminAddr
+		p.MetricsPort = metricsPort
+		if !cmd.Flags().Changed("seed") {
+			seed = proxy.NewSeed()
+		}
+		p.Rand = proxy.NewRand(seed)
 
         // Determine run label: baseline (record) vs experiment (test)
         runLabel := "record"
         runDetail := "baseline"
-        if delay > 0 || failureRate > 0 {
+        if hasChaos(rules) {
             runLabel = "test"
             runDetail = "experiment"
         } else {
@@ -106,6 +135,13 @@ var httpProxyCmd = &cobra.Command{
         // Helpful startup logs so users know it is running
         fmt.Printf("Starting chaos proxy on :%d -> %s\n", port, target)
         fmt.Printf("Mode: %s (%s)\n", runLabel, runDetail)
+        fmt.Printf("Seed: %d (replay with --seed %d)\n", seed, seed)
+        if adminPort > 0 {
+            fmt.Printf("Rules admin API on %s (GET/POST /rules, PUT/DELETE /rules/{id}, POST /rules/{id}/pause|resume)\n", net.JoinHostPort(adminAddr, strconv.Itoa(adminPort)))
+        }
+        if metricsPort > 0 {
+            fmt.Printf("Prometheus metrics on :%d/metrics\n", metricsPort)
+        }
 
         // If user did not specify --output, pick default by mode
         // record -> baseline.ndjson, test -> experiment.ndjson
@@ -117,12 +153,43 @@ var httpProxyCmd = &cobra.Command{
             }
         }
 
+		// Stream metrics while the proxy runs so long runs use constant memory
+		// and a killed process keeps everything up to the last flush
+		outputPath := ""
+		if output != "" {
+			outputPath, err = utils.ResolveOutputPath(output)
+			if err != nil {
+				fmt.Println("Failed to resolve output path:", err)
+				return
+			}
+			opts := metrics.StreamOptions{
+				FlushEvery:  flushEvery,
+				RotateBytes: rotateBytes,
+				RotateEvery: rotateEvery,
+				Gzip:        rotateGzip,
+			}
+			if err := p.Metrics.StreamTo(outputPath, opts); err != nil {
+				fmt.Println("Failed to open metrics output:", err)
+				return
+			}
+			fmt.Println("Streaming metrics to", outputPath)
+		}
+
+		// Reload the rules file on change or SIGHUP while the proxy runs
+		if rulesFile != "" {
+			watchCtx, stopWatch := context.WithCancel(context.Background())
+			defer stopWatch()
+			go p.WatchRulesFile(watchCtx, rulesFile, watchEvery)
+		}
+
 		// If a duration is provided, run for that long and cancel
 		if duration > 0 {
 			ctx, cancel := context.WithTimeout(context.Background(), duration)
 			defer cancel()
 
+			stopped := make(chan struct{})
 			go func() {
+				defer close(stopped)
 				// p.StartWithCtx is assumed to exist in your proxy package.
 				// If it is named differently, update accordingly.
 				if err := p.StartWithCtx(ctx); err != nil && err != http.ErrServerClosed {
@@ -130,7 +197,9 @@ var httpProxyCmd = &cobra.Command{
 				}
 			}()
 
-			<-ctx.Done()
+			// wait for the shutdown to drain in-flight requests so their
+			// metrics are recorded before the stream is closed
+			<-stopped
 		} else {
 			// Block until the proxy stops (Start handles signals internally)
 			if err := p.Start(); err != nil && err != http.ErrServerClosed {
@@ -138,21 +207,14 @@ var httpProxyCmd = &cobra.Command{
 			}
 		}
 
-		// At this point proxy was stopped; dump metrics
+		// At this point proxy was stopped; flush the rest of the metrics
         fmt.Println("Proxy stopped; preparing metrics output...")
         
-        if output == "" {
+        if outputPath == "" {
             fmt.Println("No output file provided; skipping metrics write.")
             return
         }
-		outputPath, err := utils.ResolveOutputPath(output)
-		if err != nil {
-			fmt.Println("Failed to resolve output path:", err)
-			return
-		}
-		fmt.Println("Resolved output path:", outputPath)
-
-		if err := p.Metrics.WriteNDJSON(outputPath); err != nil {
+		if err := p.Metrics.Close(); err != nil {
 			fmt.Println("Failed to write metrics:", err)
 		} else {
 			fmt.Println("Metrics written to", outputPath)
@@ -160,3 +222,66 @@ var httpProxyCmd = &cobra.Command{
 
 	},
 }
+
+// buildRules loads rules from --rules, or builds a single rule from the flags
+func buildRules(cmd *cobra.Command, method string) ([]proxy.ChaosRule, error) {
+	if rulesFile == "" {
+		rules := []proxy.ChaosRule{
+			{
+				Path:        rulePath,
+				Method:      method,
+				Delay:       proxy.Duration(delay),
+				FailureRate: failureRate,
+				// It's probably better to make status and body flags; using defaults here
+				StatusCode: 503,
+				ErrorBody:  `{"error":"chaos injected"}`,
+			},
+		}
+		return rules, proxy.PrepareRules(rules)
+	}
+
+	for _, name := range []string{"path", "method", "delay", "failure-rate"} {
+		if cmd.Flags().Changed(name) {
+			return nil, fmt.Errorf("--%s cannot be combined with --rules; define it in the rules file instead", name)
+		}
+	}
+	rules, err := proxy.LoadRulesFile(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+	fmt.Printf("Loaded %d chaos rules from %s\n", len(rules), rulesFile)
+	return rules, nil
+}
+
+func hasChaos(rules []proxy.ChaosRule) bool {
+	for i := range rules {
+		if rules[i].HasChaos() {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSize reads a byte size such as "512KB", "100MB" or "2GB" (powers of
+// 1024); a bare number is bytes and "" is 0
+func parseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, mult = strings.TrimSpace(n), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 100MB)", size)
+	}
+	return n * mult, nil
+}
@@ -1,18 +1,9 @@
 package analyze
 
-import "time"
+import "github.com/syedowais312/chaos-cli/pkg/metrics"
 
-// RequestMetric mirrors the metrics emitted by the proxy (NDJSON)
-type RequestMetric struct {
-    Timestamp    time.Time `json:"timestamp"`
-    Method       string    `json:"method"`
-    Path         string    `json:"path"`
-    StatusCode   int       `json:"status_code"`
-    LatencyMs    int64     `json:"latency_ms"`
-    ChaosApplied bool      `json:"chaos_applied"`
-    ChaosType    string    `json:"chaos_type"`
-    BackendError bool      `json:"backend_error"`
-}
+// RequestMetric is the proxy's metric record; both sides share one schema
+type RequestMetric = metrics.RequestMetric
 
 // EndpointStats aggregates metrics for a single method+path
 type EndpointStats struct {
@@ -3,14 +3,18 @@ package metrics
 import (
 	"bufio"
 	"encoding/json"
+	"io"
 	"os"
 	"sync"
 )
 
-// MetricsCollector is thread-safe and stores metrics in memory
+// MetricsCollector is thread-safe and stores metrics in memory, or streams
+// them to a file after StreamTo
 type MetricsCollector struct {
 	mu      sync.Mutex
 	metrics []RequestMetric
+	events  []Event
+	stream  *stream
 }
 
 // New creates collector
@@ -23,11 +27,35 @@ func New() *MetricsCollector {
 // RecordRequest appends a metric (concurrent-safe)
 func (c *MetricsCollector) RecordRequest(m RequestMetric) {
 	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stream != nil {
+		c.add(m)
+		return
+	}
 	c.metrics = append(c.metrics, m)
-	c.mu.Unlock()
 }
 
-// GetAll returns a snapshot copy of collected metrics
+// RecordEvent appends an event (concurrent-safe)
+func (c *MetricsCollector) RecordEvent(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stream != nil {
+		c.add(e)
+		return
+	}
+	c.events = append(c.events, e)
+}
+
+// GetEvents returns a snapshot copy of recorded events held in memory
+func (c *MetricsCollector) GetEvents() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp := make([]Event, len(c.events))
+	copy(cp, c.events)
+	return cp
+}
+
+// GetAll returns a snapshot copy of collected metrics held in memory
 func (c *MetricsCollector) GetAll() []RequestMetric {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -40,6 +68,7 @@ func (c *MetricsCollector) GetAll() []RequestMetric {
 func (c *MetricsCollector) Clear() {
 	c.mu.Lock()
 	c.metrics = c.metrics[:0]
+	c.events = c.events[:0]
 	c.mu.Unlock()
 }
 
@@ -54,19 +83,10 @@ func (c *MetricsCollector) WriteNDJSON(path string) error {
 	}
 	defer f.Close()
 
-	w := bufio.NewWriter(f)
-	enc := json.NewEncoder(w)
-
-	for _, m := range c.metrics {
-		if err := enc.Encode(m); err != nil {
-			w.Flush()
-			return err
-		}
-	}
-	if err := w.Flush(); err != nil {
+	if err := writeHeader(f); err != nil {
 		return err
 	}
-	return nil
+	return c.encodeAll(f)
 }
 
 // AppendNDJSON appends metrics to a file (useful to stream metrics)
@@ -80,17 +100,39 @@ func (c *MetricsCollector) AppendNDJSON(path string) error {
 	}
 	defer f.Close()
 
+	// a new file starts with the schema header
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		if err := writeHeader(f); err != nil {
+			return err
+		}
+	}
+	return c.encodeAll(f)
+}
+
+// encodeAll writes metrics and events in timestamp order; must be called with mu held
+func (c *MetricsCollector) encodeAll(f io.Writer) error {
 	w := bufio.NewWriter(f)
 	enc := json.NewEncoder(w)
 
+	ei := 0
 	for _, m := range c.metrics {
+		for ei < len(c.events) && !c.events[ei].Timestamp.After(m.Timestamp) {
+			if err := enc.Encode(c.events[ei]); err != nil {
+				w.Flush()
+				return err
+			}
+			ei++
+		}
 		if err := enc.Encode(m); err != nil {
 			w.Flush()
 			return err
 		}
 	}
-	if err := w.Flush(); err != nil {
-		return err
+	for _, e := range c.events[ei:] {
+		if err := enc.Encode(e); err != nil {
+			w.Flush()
+			return err
+		}
 	}
-	return nil
+	return w.Flush()
 }
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the histogram upper bounds in seconds (Prometheus defaults)
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// maxPromPaths bounds the raw paths of requests no rule matched; further
+// ones are counted under "other"
+const maxPromPaths = 1000
+
+// promMethods are the methods kept as labels; anything else is "OTHER"
+var promMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true,
+	http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true,
+	http.MethodOptions: true, http.MethodConnect: true, http.MethodTrace: true,
+}
+
+// Prometheus keeps live counters and latency histograms per method and path
+// and serves them in the Prometheus text exposition format. Requests that
+// matched a rule are labelled with the rule's path pattern, so the number of
+// series stays bounded by the rules rather than by the URLs clients send.
+type Prometheus struct {
+	mu        sync.Mutex
+	endpoints map[endpointKey]*endpointStats
+	chaos     map[chaosKey]uint64
+	rawPaths  int // endpoints labelled with a raw request path
+}
+
+type endpointKey struct{ method, path string }
+
+type chaosKey struct {
+	endpointKey
+	chaosType, rule string
+}
+
+type endpointStats struct {
+	status  map[string]uint64 // by status class
+	buckets []uint64          // per latencyBuckets entry, not cumulative
+	count   uint64
+	sum     float64 // seconds
+}
+
+// NewPrometheus creates an empty set of live metrics
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		endpoints: make(map[endpointKey]*endpointStats),
+		chaos:     make(map[chaosKey]uint64),
+	}
+}
+
+// Observe counts one request that took latency. route is the path pattern
+// of the rule that matched it, or "" to label the request with its raw path.
+// The latency is passed separately because m only keeps whole milliseconds.
+func (p *Prometheus) Observe(m RequestMetric, route string, latency time.Duration) {
+	seconds := latency.Seconds()
+	method := m.Method
+	if !promMethods[method] {
+		method = "OTHER"
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := endpointKey{method, route}
+	if route == "" {
+		key.path = m.Path
+	}
+	s, ok := p.endpoints[key]
+	if !ok {
+		if route == "" && p.rawPaths >= maxPromPaths {
+			key.path = "other"
+			s = p.endpoints[key]
+		}
+		if s == nil {
+			s = &endpointStats{status: make(map[string]uint64), buckets: make([]uint64, len(latencyBuckets))}
+			p.endpoints[key] = s
+			if route == "" && key.path != "other" {
+				p.rawPaths++
+			}
+		}
+	}
+	s.status[statusClass(m.StatusCode)]++
+	s.count++
+	s.sum += seconds
+	if i, _ := slices.BinarySearch(latencyBuckets, seconds); i < len(latencyBuckets) {
+		s.buckets[i]++
+	}
+	if m.ChaosApplied {
+		p.chaos[chaosKey{key, m.ChaosType, m.Rule}]++
+	}
+}
+
+// statusClass groups status codes as 2xx, 5xx, ...; "none" when the client
+// got no response at all
+func statusClass(code int) string {
+	if code <= 0 {
+		return "none"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// ServeHTTP writes the current metrics
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// WriteTo writes the current metrics in the text exposition format
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	keys := make([]endpointKey, 0, len(p.endpoints))
+	for k := range p.endpoints {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b endpointKey) int {
+		return strings.Compare(a.method+" "+a.path, b.method+" "+b.path)
+	})
+
+	b.WriteString("# HELP chaos_proxy_requests_total Requests handled by the chaos proxy.\n")
+	b.WriteString("# TYPE chaos_proxy_requests_total counter\n")
+	for _, k := range keys {
+		s := p.endpoints[k]
+		classes := make([]string, 0, len(s.status))
+		for c := range s.status {
+			classes = append(classes, c)
+		}
+		slices.Sort(classes)
+		for _, c := range classes {
+			fmt.Fprintf(&b, "chaos_proxy_requests_total{%s,status_class=\"%s\"} %d\n", k.labels(), c, s.status[c])
+		}
+	}
+
+	b.WriteString("# HELP chaos_proxy_request_duration_seconds Request latency as seen by clients, including injected delay.\n")
+	b.WriteString("# TYPE chaos_proxy_request_duration_seconds histogram\n")
+	for _, k := range keys {
+		s := p.endpoints[k]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(&b, "chaos_proxy_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", k.labels(), strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "chaos_proxy_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", k.labels(), s.count)
+		fmt.Fprintf(&b, "chaos_proxy_request_duration_seconds_sum{%s} %s\n", k.labels(), strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "chaos_proxy_request_duration_seconds_count{%s} %d\n", k.labels(), s.count)
+	}
+
+	chaos := make([]chaosKey, 0, len(p.chaos))
+	for k := range p.chaos {
+		chaos = append(chaos, k)
+	}
+	slices.SortFunc(chaos, func(a, b chaosKey) int {
+		return strings.Compare(a.method+" "+a.path+" "+a.chaosType+" "+a.rule, b.method+" "+b.path+" "+b.chaosType+" "+b.rule)
+	})
+	b.WriteString("# HELP chaos_proxy_chaos_injected_total Requests that had chaos applied, by type and rule.\n")
+	b.WriteString("# TYPE chaos_proxy_chaos_injected_total counter\n")
+	for _, k := range chaos {
+		fmt.Fprintf(&b, "chaos_proxy_chaos_injected_total{%s,chaos_type=\"%s\",rule=\"%s\"} %d\n", k.labels(), escapeLabel(k.chaosType), escapeLabel(k.rule), p.chaos[k])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (k endpointKey) labels() string {
+	return fmt.Sprintf("method=\"%s\",path=\"%s\"", escapeLabel(k.method), escapeLabel(k.path))
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segmentTime names rotated segments after the time they were opened, so
+// they sort in order and before the active file
+const segmentTime = "20060102T150405.000Z"
+
+// segmentFile is the output of a stream. It always writes to path; with
+// rotation on, a full segment is renamed to <name>-<opened><ext> and,
+// optionally, compressed in the background.
+type segmentFile struct {
+	path   string
+	opts   StreamOptions
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	gz    sync.WaitGroup
+	mu    sync.Mutex // guards gzErr
+	gzErr error
+}
+
+// openSegmentFile refuses to start over segments of an earlier run, which
+// analyze would otherwise read as part of this one
+func openSegmentFile(path string, opts StreamOptions) (*segmentFile, error) {
+	old, err := Segments(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(old) > 0 {
+		return nil, fmt.Errorf("%d rotated segments of %s from an earlier run exist (first %s); move them away or use another output", len(old), path, filepath.Base(old[0]))
+	}
+	f, err := createSegment(path)
+	if err != nil {
+		return nil, err
+	}
+	return &segmentFile{path: path, opts: opts, f: f, opened: time.Now()}, nil
+}
+
+// createSegment creates path and writes the schema header, which does not
+// count towards the segment size
+func createSegment(path string) (*os.File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeHeader(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Write appends b to the current segment, rotating first when it is due.
+// Callers write whole lines, so segments always end on a line boundary.
+func (s *segmentFile) Write(b []byte) (int, error) {
+	if s.size > 0 && s.due(len(b)) {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *segmentFile) due(n int) bool {
+	if s.opts.RotateBytes > 0 && s.size+int64(n) > s.opts.RotateBytes {
+		return true
+	}
+	return s.opts.RotateEvery > 0 && time.Since(s.opened) >= s.opts.RotateEvery
+}
+
+// rotate closes the current segment, moves it aside and starts a new one
+func (s *segmentFile) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	name := segmentName(s.path, s.opened)
+	if err := os.Rename(s.path, name); err != nil {
+		return err
+	}
+	if s.opts.Gzip {
+		s.gz.Add(1)
+		go func() {
+			defer s.gz.Done()
+			if err := gzipFile(name); err != nil {
+				s.mu.Lock()
+				s.gzErr = errors.Join(s.gzErr, err)
+				s.mu.Unlock()
+			}
+		}()
+	}
+
+	f, err := createSegment(s.path)
+	if err != nil {
+		return err
+	}
+	s.f, s.size, s.opened = f, 0, time.Now()
+	return nil
+}
+
+// Close closes the active segment and waits for pending compression
+func (s *segmentFile) Close() error {
+	err := s.f.Close()
+	s.gz.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(err, s.gzErr)
+}
+
+// segmentName picks a free name for a segment of path opened at t
+func segmentName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	name := fmt.Sprintf("%s-%s%s", base, t.UTC().Format(segmentTime), ext)
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%s-%d%s", base, t.UTC().Format(segmentTime), i, ext)
+	}
+	return name
+}
+
+// Segments lists the rotated segments of path, oldest first. Only names
+// segmentName can produce match, so other files sharing the prefix are left
+// out.
+func Segments(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	base := filepath.Base(strings.TrimSuffix(path, ext))
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(base) + `-(\d{8}T\d{6}\.\d{3}Z)(?:-(\d+))?` + regexp.QuoteMeta(ext) + `(?:\.gz)?$`)
+
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type segment struct {
+		name   string
+		opened string // segmentTime sorts in time order
+		n      int
+	}
+	var found []segment
+	for _, e := range entries {
+		m := re.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		n, _ := strconv.Atoi(m[2]) // "" for the first segment opened at that time
+		found = append(found, segment{filepath.Join(dir, e.Name()), m[1], n})
+	}
+	slices.SortFunc(found, func(a, b segment) int {
+		return cmp.Or(strings.Compare(a.opened, b.opened), a.n-b.n)
+	})
+	names := make([]string, len(found))
+	for i, s := range found {
+		names[i] = s.name
+	}
+	return names, nil
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// gzipFile compresses name to name.gz and removes the original. The
+// temporary file is hidden so globs over the segments never pick it up.
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".gz.tmp")
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	err = errors.Join(err, zw.Close(), dst.Close())
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compress %s: %w", name, err)
+	}
+	return os.Remove(name)
+}
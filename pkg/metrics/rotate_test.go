@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSegmentFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.ndjson")
+	f, err := openSegmentFile(path, StreamOptions{RotateBytes: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := range 10 {
+		line := fmt.Sprintf("{\"n\":%d}\n", i) // 8 bytes, two per segment
+		want = append(want, strings.TrimSpace(line))
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := Segments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 4 {
+		t.Fatalf("got %d segments %v, want 4", len(segments), segments)
+	}
+	var got []string
+	for _, name := range append(segments, path) {
+		lines := readLines(t, name)
+		if len(lines) == 0 || !strings.Contains(lines[0], SchemaName) {
+			t.Fatalf("%s does not start with a header", name)
+		}
+		got = append(got, lines[1:]...)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("records across segments = %v, want %v", got, want)
+	}
+
+	if _, err := openSegmentFile(path, StreamOptions{}); err == nil {
+		t.Error("reopening over segments of an earlier run: expected an error")
+	}
+}
+
+func TestSegments(t *testing.T) {
+	dir := t.TempDir()
+	t0 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC).Format(segmentTime)
+	t1 := time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC).Format(segmentTime)
+	for _, name := range []string{
+		"run.ndjson",
+		"run-" + t1 + ".ndjson.gz",
+		"run-" + t0 + "-1.ndjson",
+		"run-" + t0 + ".ndjson.gz",
+		"run-" + t0 + "-2.ndjson.gz",
+		// not segments of run.ndjson
+		"run-old.ndjson",
+		"run-" + t0 + ".json",
+		"running-" + t0 + ".ndjson",
+		"other-" + t0 + ".ndjson",
+		".run-" + t0 + ".ndjson.gz.tmp",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := Segments(filepath.Join(dir, "run.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, name := range got {
+		names = append(names, filepath.Base(name))
+	}
+	want := []string{
+		"run-" + t0 + ".ndjson.gz",
+		"run-" + t0 + "-1.ndjson",
+		"run-" + t0 + "-2.ndjson.gz",
+		"run-" + t1 + ".ndjson.gz",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("Segments = %v, want %v", names, want)
+	}
+}
+
+func readLines(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SchemaName identifies chaos-cli metrics files in their header line
+const SchemaName = "chaos-cli/metrics"
+
+// SchemaVersion is the version of RequestMetric and Event written by this
+// build. Bump it when a field is renamed, removed or changes meaning, and
+// teach decodeRequest to upgrade the previous version.
+//
+//	0  files without a header line, written before versioning
+//	1  header line; request identity, bytes and upstream/injected timings
+const SchemaVersion = 1
+
+// maxLine bounds a single NDJSON line when reading metrics files
+const maxLine = 1 << 20
+
+// Header is the first line of every metrics file, and of every rotated segment
+type Header struct {
+	Schema  string    `json:"schema"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+// writeHeader writes the header line for the current schema
+func writeHeader(w io.Writer) error {
+	return json.NewEncoder(w).Encode(Header{Schema: SchemaName, Version: SchemaVersion, Created: time.Now()})
+}
+
+// ReadRequests decodes the request metrics in an NDJSON metrics file,
+// upgrading records of older schema versions and skipping events. A header
+// line sets the version for the lines after it, so concatenated files work.
+// Files from a newer build, or that are not metrics files, are an error:
+// every record needs timestamp, method and status_code, and unknown fields
+// are rejected.
+func ReadRequests(r io.Reader) ([]RequestMetric, error) {
+	var out []RequestMetric
+	version := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLine)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		var probe struct {
+			Schema     string          `json:"schema"`
+			Version    int             `json:"version"`
+			Event      string          `json:"event"`
+			Timestamp  json.RawMessage `json:"timestamp"`
+			Method     json.RawMessage `json:"method"`
+			StatusCode json.RawMessage `json:"status_code"`
+		}
+		if err := json.Unmarshal(line, &probe); err != nil {
+			return nil, fmt.Errorf("line %d: failed to parse: %w", n, err)
+		}
+		if probe.Schema != "" {
+			if err := checkHeader(probe.Schema, probe.Version); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			version = probe.Version
+			continue
+		}
+		if probe.Event != "" {
+			continue
+		}
+		if probe.Timestamp == nil || probe.Method == nil || probe.StatusCode == nil {
+			return nil, fmt.Errorf("line %d: not a chaos-cli metrics file (records need timestamp, method and status_code)", n)
+		}
+		m, err := decodeRequest(version, line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: schema version %d: %w", n, version, err)
+		}
+		out = append(out, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// checkHeader rejects files this build cannot read
+func checkHeader(schema string, version int) error {
+	if schema != SchemaName {
+		return fmt.Errorf("not a chaos-cli metrics file (schema %q)", schema)
+	}
+	if version < 1 {
+		return fmt.Errorf("invalid metrics schema version %d", version)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("metrics schema version %d is newer than this build reads (up to %d); upgrade chaos-cli", version, SchemaVersion)
+	}
+	return nil
+}
+
+// decodeRequest decodes a record written with the given schema version into
+// the current RequestMetric
+func decodeRequest(version int, line []byte) (RequestMetric, error) {
+	var m RequestMetric
+	dec := json.NewDecoder(bytes.NewReader(line))
+	// a field this build does not know means the writer's schema drifted, or
+	// the file is not a metrics file. Version 0 uses the same field names as
+	// version 1 without the later additions, so it decodes as is.
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&m)
+	return m, err
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadRequests(t *testing.T) {
+	const (
+		v0  = `{"timestamp":"2025-01-01T12:00:00Z","method":"GET","path":"/a","status_code":200,"latency_ms":5,"chaos_applied":false,"chaos_type":"none","backend_error":false}`
+		v1  = `{"timestamp":"2025-01-01T12:00:01Z","request_id":"r1","method":"POST","path":"/b","status_code":503,"latency_ms":7,"upstream_ms":6,"bytes_out":12,"chaos_applied":true,"chaos_type":"failure","backend_error":false}`
+		hdr = `{"schema":"chaos-cli/metrics","version":1,"created":"2025-01-01T12:00:00Z"}`
+		evt = `{"timestamp":"2025-01-01T12:00:00Z","event":"rules_loaded","rule_set":1}`
+	)
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string // paths of the decoded requests
+	}{
+		{"version 0 without header", []string{v0, evt, v0}, []string{"/a", "/a"}},
+		{"version 1", []string{hdr, evt, v1}, []string{"/b"}},
+		{"concatenated files", []string{v0, hdr, v1, hdr, v1}, []string{"/a", "/b", "/b"}},
+		{"events only", []string{hdr, evt}, nil},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		got, err := ReadRequests(strings.NewReader(strings.Join(tt.lines, "\n")))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var paths []string
+		for _, m := range got {
+			paths = append(paths, m.Path)
+		}
+		if !slices.Equal(paths, tt.want) {
+			t.Errorf("%s: read %v, want %v", tt.name, paths, tt.want)
+		}
+	}
+
+	got, err := ReadRequests(strings.NewReader(hdr + "\n" + v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := got[0]; m.RequestID != "r1" || m.StatusCode != 503 || m.UpstreamMs != 6 || m.BytesOut != 12 || m.ChaosType != "failure" {
+		t.Errorf("decoded %+v", m)
+	}
+}
+
+func TestReadRequestsErrors(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"newer version", `{"schema":"chaos-cli/metrics","version":99}`, "newer than this build"},
+		{"invalid version", `{"schema":"chaos-cli/metrics","version":0}`, "invalid metrics schema version"},
+		{"foreign schema", `{"schema":"other/metrics","version":1}`, "not a chaos-cli metrics file"},
+		{"foreign records", `{"foo":1}`, "not a chaos-cli metrics file"},
+		{"unknown field", `{"timestamp":"2025-01-01T12:00:00Z","method":"GET","status_code":200,"colour":"red"}`, "unknown field"},
+		{"not json", `GET /a 200`, "line 1: failed to parse"},
+		{"error line number", "{\"schema\":\"chaos-cli/metrics\",\"version\":1}\n{\"foo\":1}", "line 2:"},
+	}
+	for _, tt := range tests {
+		_, err := ReadRequests(strings.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+}
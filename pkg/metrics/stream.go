@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// streamBatchBytes wakes the writer early once this much is pending
+	streamBatchBytes = 64 << 10
+	// streamMaxPending bounds memory; recording blocks while the writer catches up
+	streamMaxPending = 1 << 20
+)
+
+// StreamOptions controls how StreamTo writes and rotates its output
+type StreamOptions struct {
+	FlushEvery  time.Duration // write pending records at least this often, default 1s
+	RotateBytes int64         // start a new segment once the current one would exceed this, 0 = never
+	RotateEvery time.Duration // start a new segment this often, 0 = never
+	Gzip        bool          // compress rotated segments
+}
+
+// stream is the background NDJSON writer behind StreamTo
+type stream struct {
+	path    string
+	w       io.WriteCloser
+	buf     *bytes.Buffer // pending lines, guarded by the collector's mu
+	out     *bytes.Buffer // lines being written, owned by the writer goroutine
+	full    *sync.Cond    // signalled when buf has been handed to the writer
+	kick    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	err     error // first write error, owned by the writer goroutine
+}
+
+// StreamTo writes everything collected so far to path and then appends new
+// records in the background, in batches and at least every FlushEvery.
+// Streamed records are not kept in memory. Call Close to flush and stop.
+// Rotated segments of path left by an earlier run are an error.
+func (c *MetricsCollector) StreamTo(path string, opts StreamOptions) error {
+	f, err := openSegmentFile(path, opts)
+	if err != nil {
+		return err
+	}
+	if opts.FlushEvery <= 0 {
+		opts.FlushEvery = time.Second
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.encodeAll(f); err != nil {
+		f.Close()
+		return err
+	}
+	c.metrics = c.metrics[:0]
+	c.events = c.events[:0]
+
+	s := &stream{
+		path:    path,
+		w:       f,
+		buf:     new(bytes.Buffer),
+		out:     new(bytes.Buffer),
+		full:    sync.NewCond(&c.mu),
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	c.stream = s
+	go c.writeLoop(s, opts.FlushEvery)
+	return nil
+}
+
+// Close flushes pending records and closes the stream; later records are
+// kept in memory again
+func (c *MetricsCollector) Close() error {
+	c.mu.Lock()
+	s := c.stream
+	c.stream = nil
+	c.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+
+	close(s.done)
+	<-s.stopped
+	if err := s.w.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
+
+// add encodes v into the pending batch; must be called with mu held
+func (c *MetricsCollector) add(v any) {
+	s := c.stream
+	for s.buf.Len() >= streamMaxPending && c.stream == s {
+		s.full.Wait()
+	}
+	if c.stream != s {
+		// closed while waiting
+		c.keep(v)
+		return
+	}
+	_ = json.NewEncoder(s.buf).Encode(v)
+	if s.buf.Len() >= streamBatchBytes {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// keep stores v in memory; must be called with mu held
+func (c *MetricsCollector) keep(v any) {
+	switch v := v.(type) {
+	case RequestMetric:
+		c.metrics = append(c.metrics, v)
+	case Event:
+		c.events = append(c.events, v)
+	}
+}
+
+func (c *MetricsCollector) writeLoop(s *stream, every time.Duration) {
+	defer close(s.stopped)
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.kick:
+		case <-s.done:
+			c.flush(s)
+			return
+		}
+		c.flush(s)
+	}
+}
+
+// flush hands the pending batch to the writer goroutine and writes it
+func (c *MetricsCollector) flush(s *stream) {
+	c.mu.Lock()
+	s.buf, s.out = s.out, s.buf
+	s.full.Broadcast()
+	c.mu.Unlock()
+
+	if s.out.Len() == 0 {
+		return
+	}
+	if _, err := s.w.Write(s.out.Bytes()); err != nil && s.err == nil {
+		// Close reports it too, but a long run should not find out at the end
+		log.Printf("metrics: writing %s failed, records are being dropped: %v", s.path, err)
+		s.err = err
+	}
+	s.out.Reset()
+}
@@ -2,13 +2,37 @@ package metrics
 
 import "time"
 
+// RequestMetric is one proxied request. It is the canonical record of the
+// metrics file format, shared with the analyzer; see SchemaVersion.
 type RequestMetric struct {
-	Timestamp    time.Time `json:"timestamp"`
-	Method       string    `json:"method"`
-	Path         string    `json:"path"`
-	StatusCode   int       `json:"status_code"`
-	LatencyMs    int64     `json:"latency_ms"`
-	ChaosApplied bool      `json:"chaos_applied"`
-	ChaosType    string    `json:"chaos_type"`    // "delay", "failure", "none"
-	BackendError bool      `json:"backend_error"` // true if backend returned 5xx or proxy detected error
+	Timestamp      time.Time `json:"timestamp"`
+	RequestID      string    `json:"request_id,omitempty"` // X-Request-Id from the client, or generated by the proxy
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Query          string    `json:"query,omitempty"`       // raw query string
+	ClientAddr     string    `json:"client_addr,omitempty"` // client address (host:port)
+	StatusCode     int       `json:"status_code"`
+	LatencyMs      int64     `json:"latency_ms"`
+	UpstreamMs     int64     `json:"upstream_ms"`        // time until the backend sent response headers; 0 when it was not contacted
+	DelayMs        int64     `json:"delay_ms,omitempty"` // injected delay, included in latency_ms
+	BytesIn        int64     `json:"bytes_in"`           // request body bytes read from the client
+	BytesOut       int64     `json:"bytes_out"`          // response body bytes written to the client
+	ChaosApplied   bool      `json:"chaos_applied"`
+	ChaosType      string    `json:"chaos_type"`                // "delay", "failure", "none"
+	BackendError   bool      `json:"backend_error"`             // true if backend returned 5xx or proxy detected error
+	GatewayTimeout bool      `json:"gateway_timeout,omitempty"` // proxy gave up on the backend (upstream_timeout)
+	RuleSet        int       `json:"rule_set,omitempty"`        // version of the rule set active for this request
+	Rule           string    `json:"rule,omitempty"`            // ID of the rule that matched
+	Cohort         string    `json:"cohort,omitempty"`          // "in" or "out" for rules with a client cohort
+}
+
+// Event records a change during a run, such as a rules reload. Events share
+// the NDJSON stream with request metrics and are told apart by the "event" key.
+type Event struct {
+	Timestamp time.Time `json:"timestamp"`
+	Event     string    `json:"event"` // "rules_loaded", "rules_reload", "rules_reload_failed", "rules_changed"
+	RuleSet   int       `json:"rule_set,omitempty"`
+	Source    string    `json:"source,omitempty"` // file path, "sighup", or admin operation
+	Rules     []string  `json:"rules,omitempty"`  // IDs of the rules in the new set
+	Error     string    `json:"error,omitempty"`
 }
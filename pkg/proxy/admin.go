@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxAdminBody caps rule payloads sent to the admin API
+const maxAdminBody = 1 << 20
+
+// AdminHandler serves the runtime rules API:
+//
+//	GET    /rules              list rules
+//	POST   /rules              create a rule
+//	GET    /rules/{id}         show a rule
+//	PUT    /rules/{id}         replace a rule
+//	DELETE /rules/{id}         delete a rule
+//	POST   /rules/{id}/pause   pause a rule (optional ?for=30s)
+//	POST   /rules/{id}/resume  resume a paused rule
+func AdminHandler(store *RuleStore) http.Handler {
+	a := &adminAPI{store: store}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rules", a.list)
+	mux.HandleFunc("POST /rules", a.create)
+	mux.HandleFunc("GET /rules/{id}", a.get)
+	mux.HandleFunc("PUT /rules/{id}", a.update)
+	mux.HandleFunc("DELETE /rules/{id}", a.delete)
+	mux.HandleFunc("POST /rules/{id}/pause", a.pause)
+	mux.HandleFunc("POST /rules/{id}/resume", a.resume)
+	return mux
+}
+
+type adminAPI struct {
+	store *RuleStore
+}
+
+type rulesResponse struct {
+	Version int         `json:"version"`
+	Rules   []ChaosRule `json:"rules"`
+}
+
+func (a *adminAPI) list(w http.ResponseWriter, r *http.Request) {
+	snapshot, version := a.store.Snapshot()
+	rules := make([]ChaosRule, len(snapshot))
+	for i, rule := range snapshot {
+		rules[i] = *rule
+	}
+	writeJSON(w, http.StatusOK, rulesResponse{Version: version, Rules: rules})
+}
+
+func (a *adminAPI) get(w http.ResponseWriter, r *http.Request) {
+	rule, err := a.store.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (a *adminAPI) create(w http.ResponseWriter, r *http.Request) {
+	rule, err := decodeRule(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rule, err = a.store.Add(rule)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func (a *adminAPI) update(w http.ResponseWriter, r *http.Request) {
+	rule, err := decodeRule(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rule, err = a.store.Update(r.PathValue("id"), rule)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (a *adminAPI) delete(w http.ResponseWriter, r *http.Request) {
+	if err := a.store.Delete(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminAPI) pause(w http.ResponseWriter, r *http.Request) {
+	var d time.Duration
+	if v := r.URL.Query().Get("for"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid pause duration %q", v)})
+			return
+		}
+		d = parsed
+	}
+	rule, err := a.store.Pause(r.PathValue("id"), d)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func (a *adminAPI) resume(w http.ResponseWriter, r *http.Request) {
+	rule, err := a.store.Resume(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func decodeRule(w http.ResponseWriter, r *http.Request) (ChaosRule, error) {
+	var rule ChaosRule
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rule); err != nil {
+		return ChaosRule{}, fmt.Errorf("invalid rule JSON: %w", err)
+	}
+	// the API must not read files off the proxy host
+	if rule.Mock != nil && rule.Mock.BodyFile != "" {
+		return ChaosRule{}, errors.New("mock.body_file is only allowed in rules files; use mock.body")
+	}
+	return rule, nil
+}
+
+// writeError maps store errors to HTTP status codes; anything else is a validation error
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrRuleNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrRuleExists):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
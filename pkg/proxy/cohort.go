@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// CohortSpec limits a rule's chaos to a sticky fraction of clients. Clients
+// are hashed by Key, so the same client is always in or out of the cohort;
+// changing Salt picks a different set of clients.
+type CohortSpec struct {
+	Fraction float64 `json:"fraction" yaml:"fraction"`
+	Key      string  `json:"key,omitempty" yaml:"key,omitempty"` // see clientKey, default "ip"
+	Salt     string  `json:"salt,omitempty" yaml:"salt,omitempty"`
+}
+
+// Validate reports invalid cohort settings
+func (c *CohortSpec) Validate() error {
+	var errs []error
+	if c.Fraction <= 0 || c.Fraction > 1 {
+		errs = append(errs, fmt.Errorf("cohort.fraction must be greater than 0.0 and at most 1.0, got %g", c.Fraction))
+	}
+	if err := validateClientKey(c.Key); err != nil {
+		errs = append(errs, fmt.Errorf("cohort.key: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// contains reports whether the request's client is in the cohort
+func (c *CohortSpec) contains(r *http.Request) bool {
+	key := c.Key
+	if key == "" {
+		key = "ip"
+	}
+	sum := sha256.Sum256([]byte(c.Salt + "\x00" + clientKey(r, key)))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64 < c.Fraction
+}
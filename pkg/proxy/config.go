@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Duration is a time.Duration that reads and writes as a string such as "250ms"
+type Duration time.Duration
+
+// String formats the duration like time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON decodes a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"250ms\", got %s", b)
+	}
+	return d.parse(s)
+}
+
+// MarshalYAML encodes the duration as a string
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+// UnmarshalYAML decodes a duration string
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: duration must be a string like \"250ms\"", node.Line)
+	}
+	if err := d.parse(node.Value); err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	return nil
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (use values like \"250ms\" or \"2s\")", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// RulesFile is the on-disk layout of a rules file
+type RulesFile struct {
+	Rules []ChaosRule `json:"rules" yaml:"rules"`
+}
+
+// LoadRulesFile reads rules from a YAML or JSON file (chosen by extension)
+func LoadRulesFile(path string) ([]ChaosRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := decodeRules(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// mock body files are relative to the rules file, not the working directory
+	dir := filepath.Dir(path)
+	for i := range rules {
+		if m := rules[i].Mock; m != nil && m.BodyFile != "" && !filepath.IsAbs(m.BodyFile) {
+			m.BodyFile = filepath.Join(dir, m.BodyFile)
+		}
+	}
+	if err := PrepareRules(rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// ParseRules decodes and validates rules; ext selects JSON for ".json" and YAML otherwise
+func ParseRules(data []byte, ext string) ([]ChaosRule, error) {
+	rules, err := decodeRules(data, ext)
+	if err != nil {
+		return nil, err
+	}
+	if err := PrepareRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// decodeRules decodes a rules file without validating the rules
+func decodeRules(data []byte, ext string) ([]ChaosRule, error) {
+	var file RulesFile
+	if strings.EqualFold(ext, ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&file); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&file); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+	}
+	if len(file.Rules) == 0 {
+		return nil, errors.New("no rules defined (expected a top-level \"rules\" list)")
+	}
+	return file.Rules, nil
+}
+
+// PrepareRules normalizes and validates rules in place, assigning IDs to
+// rules without one. All problems are reported together.
+func PrepareRules(rules []ChaosRule) error {
+	var errs []error
+	seen := make(map[string]int, len(rules))
+	for i := range rules {
+		r := &rules[i]
+		r.Normalize()
+		if r.ID == "" {
+			r.ID = fmt.Sprintf("rule-%d", i+1)
+		}
+		if prev, ok := seen[r.ID]; ok {
+			errs = append(errs, fmt.Errorf("rule %d: duplicate id %q (also used by rule %d)", i+1, r.ID, prev))
+		}
+		seen[r.ID] = i + 1
+		for _, err := range splitErrors(r.Validate()) {
+			errs = append(errs, fmt.Errorf("rule %d (%s): %w", i+1, r.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// splitErrors flattens an errors.Join result so each problem gets its own prefix
+func splitErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Corruption modes for CorruptSpec.Mode
+const (
+	CorruptTruncate      = "truncate"       // cut the body at Bytes or Percent
+	CorruptFlip          = "flip"           // flip Bytes random bytes (default 1)
+	CorruptInvalidJSON   = "invalid_json"   // break the JSON syntax
+	CorruptDropField     = "drop_field"     // remove Field from a JSON body
+	CorruptContentLength = "content_length" // announce Bytes (default 1024) more than are sent
+)
+
+// maxCorruptBody caps how much of a response is buffered for corruption;
+// larger responses pass through untouched
+const maxCorruptBody = 10 << 20
+
+// CorruptSpec mutates upstream responses before they reach the client.
+// Rate is the fraction of matching requests that are corrupted (0 means all).
+type CorruptSpec struct {
+	Mode    string  `json:"mode" yaml:"mode"`
+	Bytes   int64   `json:"bytes,omitempty" yaml:"bytes,omitempty"`
+	Percent float64 `json:"percent,omitempty" yaml:"percent,omitempty"`
+	Field   string  `json:"field,omitempty" yaml:"field,omitempty"`
+	Rate    float64 `json:"rate,omitempty" yaml:"rate,omitempty"`
+
+	field []any // compiled Field
+}
+
+// Validate reports invalid corruption settings and compiles Field
+func (c *CorruptSpec) Validate() error {
+	var errs []error
+	switch c.Mode {
+	case CorruptTruncate:
+		if c.Bytes == 0 && c.Percent == 0 {
+			errs = append(errs, errors.New("corrupt.bytes or corrupt.percent is required for truncate"))
+		}
+	case CorruptDropField:
+		steps, err := parseJSONPath(c.Field)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("corrupt.field: %w", err))
+		}
+		c.field = steps
+	case CorruptFlip, CorruptInvalidJSON, CorruptContentLength:
+	default:
+		errs = append(errs, fmt.Errorf("unknown corrupt.mode %q (use truncate, flip, invalid_json, drop_field or content_length)", c.Mode))
+	}
+	if c.Bytes < 0 {
+		errs = append(errs, fmt.Errorf("corrupt.bytes must not be negative, got %d", c.Bytes))
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		errs = append(errs, fmt.Errorf("corrupt.percent must be between 0 and 100, got %g", c.Percent))
+	}
+	if c.Rate < 0 || c.Rate > 1 {
+		errs = append(errs, fmt.Errorf("corrupt.rate must be between 0.0 and 1.0, got %g", c.Rate))
+	}
+	return errors.Join(errs...)
+}
+
+// apply corrupts resp in place and reports whether it changed anything
+func (c *CorruptSpec) apply(resp *http.Response, rng *Rand) (bool, error) {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return false, nil
+	}
+	jsonMode := c.Mode == CorruptInvalidJSON || c.Mode == CorruptDropField
+	if jsonMode && resp.Header.Get("Content-Encoding") != "" {
+		return false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCorruptBody+1))
+	if err != nil {
+		return false, err
+	}
+	if len(body) > maxCorruptBody {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return false, nil
+	}
+	resp.Body.Close()
+
+	out, ok := c.corrupt(body, rng)
+	if !ok {
+		out = body
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(out))
+	resp.ContentLength = int64(len(out))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+	if ok && c.Mode == CorruptContentLength {
+		extra := c.Bytes
+		if extra == 0 {
+			extra = 1024
+		}
+		// the client waits for bytes that never come
+		resp.Header.Set("Content-Length", strconv.FormatInt(int64(len(out))+extra, 10))
+	}
+	return ok, nil
+}
+
+// corrupt returns the mutated body, or false when the mode does not apply
+func (c *CorruptSpec) corrupt(body []byte, rng *Rand) ([]byte, bool) {
+	switch c.Mode {
+	case CorruptTruncate:
+		n := int64(len(body))
+		if c.Bytes > 0 {
+			n = min(n, c.Bytes)
+		}
+		if c.Percent > 0 {
+			n = min(n, int64(float64(len(body))*c.Percent/100))
+		}
+		return body[:n], n < int64(len(body))
+
+	case CorruptFlip:
+		if len(body) == 0 {
+			return nil, false
+		}
+		out := bytes.Clone(body)
+		flips := max(c.Bytes, 1)
+		for range flips {
+			i := rng.Intn(len(out))
+			out[i] ^= byte(1 + rng.Intn(255))
+		}
+		return out, true
+
+	case CorruptInvalidJSON:
+		trimmed := bytes.TrimRight(body, " \t\r\n")
+		if len(trimmed) == 0 {
+			return []byte("{"), true
+		}
+		// drop the closing bracket and leave a dangling separator
+		return append(bytes.Clone(trimmed[:len(trimmed)-1]), ',', '"'), true
+
+	case CorruptDropField:
+		var doc any
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil || !deleteJSON(doc, c.field) {
+			return nil, false
+		}
+		out, err := json.Marshal(doc)
+		if err != nil {
+			return nil, false
+		}
+		return out, true
+
+	case CorruptContentLength:
+		return body, true
+	}
+	return nil, false
+}
+
+// deleteJSON removes the value at steps from a decoded JSON document
+func deleteJSON(doc any, steps []any) bool {
+	if len(steps) == 0 {
+		return false
+	}
+	parent, ok := walkJSON(doc, steps[:len(steps)-1])
+	if !ok {
+		return false
+	}
+	switch last := steps[len(steps)-1].(type) {
+	case string:
+		obj, ok := parent.(map[string]any)
+		if !ok {
+			return false
+		}
+		if _, found := obj[last]; !found {
+			return false
+		}
+		delete(obj, last)
+		return true
+	case int:
+		// arrays cannot shrink in place through an interface, so null the element
+		arr, ok := parent.([]any)
+		if !ok || last >= len(arr) {
+			return false
+		}
+		arr[last] = nil
+		return true
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Fault kinds for ChaosRule.Fault: what an injected failure looks like to the client
+const (
+	FaultStatus       = "status"        // HTTP error response (default)
+	FaultReset        = "reset"         // TCP RST instead of a response
+	FaultCloseHeaders = "close_headers" // close after part of the status line and headers
+	FaultCloseBody    = "close_body"    // forward the real response but close mid-body
+	FaultHang         = "hang"          // accept and never answer
+)
+
+var knownFaults = map[string]bool{
+	FaultStatus:       true,
+	FaultReset:        true,
+	FaultCloseHeaders: true,
+	FaultCloseBody:    true,
+	FaultHang:         true,
+}
+
+// defaultCutAfter is where close_body cuts when the upstream length is unknown
+const defaultCutAfter = 512
+
+// errBodyCut aborts the reverse proxy copy for close_body faults
+var errBodyCut = errors.New("chaos: response body cut")
+
+// writeFailure sends the rule's injected HTTP error response and returns its
+// status. With Responses set, one is picked at random by weight.
+func writeFailure(w http.ResponseWriter, rule *ChaosRule, rng *Rand) int {
+	resp := FailureResponse{Status: rule.failureStatus(), Body: rule.ErrorBody}
+	if len(rule.Responses) > 0 {
+		resp = pickResponse(rule.Responses, rng)
+	}
+	if resp.Body == "" {
+		resp.Body = `{"error":"chaos injected"}`
+	}
+	if resp.ContentType == "" {
+		resp.ContentType = "application/json"
+	}
+
+	w.Header().Set("Content-Type", resp.ContentType)
+	for k, v := range rule.Headers {
+		w.Header().Set(k, v)
+	}
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(resp.Status)
+	_, _ = w.Write([]byte(resp.Body))
+	return resp.Status
+}
+
+// pickResponse chooses a response with probability proportional to its weight
+func pickResponse(responses []FailureResponse, rng *Rand) FailureResponse {
+	total := 0.0
+	for i := range responses {
+		total += responses[i].weight()
+	}
+	x := rng.Float64() * total
+	for i := range responses {
+		x -= responses[i].weight()
+		if x < 0 {
+			return responses[i]
+		}
+	}
+	return responses[len(responses)-1]
+}
+
+// injectConnectionFault breaks the client connection without a complete
+// response. When it returns true the caller must panic with
+// http.ErrAbortHandler (after recording metrics) so net/http drops the
+// connection, or resets the stream on HTTP/2 where hijacking is impossible.
+func (cp *ChaosProxy) injectConnectionFault(w http.ResponseWriter, r *http.Request, rule *ChaosRule, fault string) (abort bool) {
+	if fault == FaultHang {
+		cp.hang(r, time.Duration(rule.HangFor))
+		return true
+	}
+
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return true
+	}
+	defer conn.Close()
+
+	switch fault {
+	case FaultReset:
+		// SO_LINGER 0 makes Close send RST instead of FIN
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			_ = tcp.SetLinger(0)
+		}
+	case FaultCloseHeaders:
+		status := rule.failureStatus()
+		// lenient clients treat EOF as the end of the headers, so promise a body too
+		partial := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: 128\r\nContent-Ty", status, http.StatusText(status))
+		_, _ = buf.WriteString(partial)
+		_ = buf.Flush()
+	}
+	return false
+}
+
+// hang holds the request open until the client gives up, the proxy shuts
+// down, or max elapses (0 means no limit)
+func (cp *ChaosProxy) hang(r *http.Request, max time.Duration) {
+	var timeout <-chan time.Time
+	if max > 0 {
+		t := time.NewTimer(max)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-r.Context().Done():
+	case <-cp.stopping:
+	case <-timeout:
+	}
+}
+
+// cutWriter forwards a response until limit body bytes have been written and
+// then fails, which makes the reverse proxy abort the connection mid-body
+type cutWriter struct {
+	http.ResponseWriter
+	limit   int64
+	written int64
+	cut     bool
+}
+
+func (c *cutWriter) WriteHeader(code int) {
+	if c.limit <= 0 {
+		c.limit = defaultCutAfter
+		if n, err := strconv.ParseInt(c.Header().Get("Content-Length"), 10, 64); err == nil && n > 1 {
+			c.limit = n / 2
+		}
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *cutWriter) Write(b []byte) (int, error) {
+	if c.limit <= 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	remaining := c.limit - c.written
+	if int64(len(b)) <= remaining {
+		n, err := c.ResponseWriter.Write(b)
+		c.written += int64(n)
+		return n, err
+	}
+	n, _ := c.ResponseWriter.Write(b[:remaining])
+	c.written += int64(n)
+	_ = http.NewResponseController(c.ResponseWriter).Flush()
+	c.cut = true
+	return n, errBodyCut
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (c *cutWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
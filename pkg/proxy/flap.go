@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FlapSpec alternates a rule between an outage, where requests fail, and a
+// healthy phase, where they pass through. Each phase lasts Down or Up,
+// shifted by a random amount of up to Jitter either way. Rate is the
+// fraction of requests failing during an outage (0 means all).
+type FlapSpec struct {
+	Down   Duration `json:"down" yaml:"down"`
+	Up     Duration `json:"up" yaml:"up"`
+	Jitter Duration `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	Start  string   `json:"start,omitempty" yaml:"start,omitempty"` // "down" (default) or "up"
+	Rate   float64  `json:"rate,omitempty" yaml:"rate,omitempty"`
+}
+
+// Validate reports invalid flapping settings
+func (f *FlapSpec) Validate() error {
+	var errs []error
+	if f.Down <= 0 {
+		errs = append(errs, fmt.Errorf("flap.down must be greater than 0, got %s", f.Down))
+	}
+	if f.Up <= 0 {
+		errs = append(errs, fmt.Errorf("flap.up must be greater than 0, got %s", f.Up))
+	}
+	if f.Jitter < 0 {
+		errs = append(errs, fmt.Errorf("flap.jitter must not be negative, got %s", f.Jitter))
+	} else if f.Jitter > 0 && (f.Jitter >= f.Down || f.Jitter >= f.Up) {
+		errs = append(errs, fmt.Errorf("flap.jitter (%s) must be shorter than flap.down and flap.up", f.Jitter))
+	}
+	if f.Start != "" && f.Start != "down" && f.Start != "up" {
+		errs = append(errs, fmt.Errorf("flap.start must be down or up, got %q", f.Start))
+	}
+	if f.Rate < 0 || f.Rate > 1 {
+		errs = append(errs, fmt.Errorf("flap.rate must be between 0.0 and 1.0, got %g", f.Rate))
+	}
+	return errors.Join(errs...)
+}
+
+// phase returns how long the next phase lasts
+func (f *FlapSpec) phase(down bool, rng *Rand) time.Duration {
+	d := time.Duration(f.Up)
+	if down {
+		d = time.Duration(f.Down)
+	}
+	if f.Jitter > 0 {
+		d += time.Duration((rng.Float64()*2 - 1) * float64(f.Jitter))
+	}
+	return d
+}
+
+// flapping is the current phase of a flapping rule
+type flapping struct {
+	down  bool
+	until time.Time
+}
+
+// flapDown reports whether the rule is in an outage at now. The first
+// request to reach the rule starts the cycle.
+func (s *ruleState) flapDown(f *FlapSpec, now time.Time, rng *Rand) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.flap == nil {
+		down := f.Start != "up"
+		s.flap = &flapping{down: down, until: now.Add(f.phase(down, rng))}
+	}
+	// after a long idle stretch start a fresh phase instead of replaying every missed one
+	if now.Sub(s.flap.until) > time.Duration(f.Down+f.Up+2*f.Jitter) {
+		s.flap.until = now
+	}
+	for !now.Before(s.flap.until) {
+		s.flap.down = !s.flap.down
+		s.flap.until = s.flap.until.Add(f.phase(s.flap.down, rng))
+	}
+	return s.flap.down
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxHeaderFill caps generated header values
+const maxHeaderFill = 1 << 20
+
+// ResponseHeadersSpec changes upstream response headers before they reach the
+// client. Remove runs first, then Rewrite, Set, Add and Fill. Rate is the
+// fraction of matching requests affected (0 means all).
+type ResponseHeadersSpec struct {
+	Remove  []string          `json:"remove,omitempty" yaml:"remove,omitempty"` // names, a trailing * matches a prefix
+	Rewrite []HeaderRewrite   `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+	Set     map[string]string `json:"set,omitempty" yaml:"set,omitempty"`   // replaces existing values
+	Add     map[string]string `json:"add,omitempty" yaml:"add,omitempty"`   // appends another value
+	Fill    map[string]int    `json:"fill,omitempty" yaml:"fill,omitempty"` // appends a generated value of that many bytes
+	Rate    float64           `json:"rate,omitempty" yaml:"rate,omitempty"`
+}
+
+// HeaderRewrite substitutes Old with New in every value of Header
+type HeaderRewrite struct {
+	Header string `json:"header" yaml:"header"`
+	Old    string `json:"old" yaml:"old"`
+	New    string `json:"new" yaml:"new"`
+}
+
+// Validate reports invalid header settings
+func (h *ResponseHeadersSpec) Validate() error {
+	var errs []error
+	empty := false
+	for _, name := range h.Remove {
+		empty = empty || strings.TrimSpace(strings.TrimSuffix(name, "*")) == ""
+	}
+	for _, m := range []map[string]string{h.Set, h.Add} {
+		for name := range m {
+			empty = empty || strings.TrimSpace(name) == ""
+		}
+	}
+	for name, n := range h.Fill {
+		empty = empty || strings.TrimSpace(name) == ""
+		if n <= 0 || n > maxHeaderFill {
+			errs = append(errs, fmt.Errorf("response_headers.fill[%s] must be between 1 and %d bytes, got %d", name, maxHeaderFill, n))
+		}
+	}
+	if empty {
+		errs = append(errs, errors.New("response_headers must not contain an empty header name"))
+	}
+	for i, rw := range h.Rewrite {
+		if strings.TrimSpace(rw.Header) == "" {
+			errs = append(errs, fmt.Errorf("response_headers.rewrite[%d].header is required", i))
+		}
+		if rw.Old == "" {
+			errs = append(errs, fmt.Errorf("response_headers.rewrite[%d].old must not be empty", i))
+		}
+	}
+	if len(h.Remove) == 0 && len(h.Rewrite) == 0 && len(h.Set) == 0 && len(h.Add) == 0 && len(h.Fill) == 0 {
+		errs = append(errs, errors.New("response_headers needs remove, rewrite, set, add or fill"))
+	}
+	if h.Rate < 0 || h.Rate > 1 {
+		errs = append(errs, fmt.Errorf("response_headers.rate must be between 0.0 and 1.0, got %g", h.Rate))
+	}
+	return errors.Join(errs...)
+}
+
+// apply changes header in place
+func (h *ResponseHeadersSpec) apply(header http.Header) {
+	for _, name := range h.Remove {
+		prefix, ok := strings.CutSuffix(name, "*")
+		if !ok {
+			header.Del(name)
+			continue
+		}
+		prefix = http.CanonicalHeaderKey(prefix)
+		for k := range header {
+			if strings.HasPrefix(k, prefix) {
+				delete(header, k)
+			}
+		}
+	}
+	for _, rw := range h.Rewrite {
+		values := header.Values(rw.Header)
+		for i, v := range values {
+			values[i] = strings.ReplaceAll(v, rw.Old, rw.New)
+		}
+	}
+	for k, v := range h.Set {
+		header.Set(k, v)
+	}
+	for k, v := range h.Add {
+		header.Add(k, v)
+	}
+	for k, n := range h.Fill {
+		// name=value keeps generated Set-Cookie values parseable
+		header.Add(k, "chaos="+strings.Repeat("x", max(n-len("chaos="), 0)))
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// LatencySpec injects a random delay drawn from a distribution:
+//
+//	uniform      between min and max
+//	normal       mean and stddev
+//	lognormal    median and sigma (shape); long right tail
+//	exponential  mean
+//	pareto       min (scale) and alpha (shape); heavy tail, lower alpha = heavier
+//
+// min and max also clamp the other distributions when set. Rate is the
+// fraction of matching requests that get delayed (0 means all of them).
+type LatencySpec struct {
+	Distribution string   `json:"distribution" yaml:"distribution"`
+	Min          Duration `json:"min,omitempty" yaml:"min,omitempty"`
+	Max          Duration `json:"max,omitempty" yaml:"max,omitempty"`
+	Mean         Duration `json:"mean,omitempty" yaml:"mean,omitempty"`
+	StdDev       Duration `json:"stddev,omitempty" yaml:"stddev,omitempty"`
+	Median       Duration `json:"median,omitempty" yaml:"median,omitempty"`
+	Sigma        float64  `json:"sigma,omitempty" yaml:"sigma,omitempty"`
+	Alpha        float64  `json:"alpha,omitempty" yaml:"alpha,omitempty"`
+	Rate         float64  `json:"rate,omitempty" yaml:"rate,omitempty"`
+}
+
+// Validate reports missing or inconsistent parameters for the distribution
+func (l *LatencySpec) Validate() error {
+	var errs []error
+	for _, f := range []struct {
+		name string
+		d    Duration
+	}{{"min", l.Min}, {"max", l.Max}, {"mean", l.Mean}, {"stddev", l.StdDev}, {"median", l.Median}} {
+		if f.d < 0 {
+			errs = append(errs, fmt.Errorf("latency.%s must not be negative", f.name))
+		}
+	}
+	if l.Max > 0 && l.Min > l.Max {
+		errs = append(errs, fmt.Errorf("latency.min (%s) is greater than latency.max (%s)", l.Min, l.Max))
+	}
+	if l.Rate < 0 || l.Rate > 1 {
+		errs = append(errs, fmt.Errorf("latency.rate must be between 0.0 and 1.0, got %g", l.Rate))
+	}
+
+	switch l.Distribution {
+	case "uniform":
+		if l.Max <= 0 {
+			errs = append(errs, errors.New("latency.max is required for uniform"))
+		}
+	case "normal":
+		if l.Mean <= 0 {
+			errs = append(errs, errors.New("latency.mean is required for normal"))
+		}
+	case "lognormal":
+		if l.Median <= 0 {
+			errs = append(errs, errors.New("latency.median is required for lognormal"))
+		}
+		if l.Sigma <= 0 {
+			errs = append(errs, errors.New("latency.sigma must be greater than 0 for lognormal"))
+		}
+	case "exponential":
+		if l.Mean <= 0 {
+			errs = append(errs, errors.New("latency.mean is required for exponential"))
+		}
+	case "pareto":
+		if l.Min <= 0 {
+			errs = append(errs, errors.New("latency.min (scale) is required for pareto"))
+		}
+		if l.Alpha <= 0 {
+			errs = append(errs, errors.New("latency.alpha must be greater than 0 for pareto"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown latency.distribution %q (use uniform, normal, lognormal, exponential or pareto)", l.Distribution))
+	}
+	return errors.Join(errs...)
+}
+
+// Sample draws one delay, or 0 when this request is not selected by Rate
+func (l *LatencySpec) Sample(rng *Rand) time.Duration {
+	if l.Rate > 0 && rng.Float64() >= l.Rate {
+		return 0
+	}
+
+	var v float64 // nanoseconds
+	switch l.Distribution {
+	case "uniform":
+		v = float64(l.Min) + rng.Float64()*float64(l.Max-l.Min)
+	case "normal":
+		v = float64(l.Mean) + rng.NormFloat64()*float64(l.StdDev)
+	case "lognormal":
+		v = float64(l.Median) * math.Exp(l.Sigma*rng.NormFloat64())
+	case "exponential":
+		v = rng.ExpFloat64() * float64(l.Mean)
+	case "pareto":
+		v = float64(l.Min) / math.Pow(1-rng.Float64(), 1/l.Alpha)
+	}
+
+	if v < float64(l.Min) {
+		v = float64(l.Min)
+	}
+	if l.Max > 0 && v > float64(l.Max) {
+		v = float64(l.Max)
+	}
+	if v < 0 || math.IsNaN(v) {
+		return 0
+	}
+	// pareto can overflow without a max
+	if v > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(v)
+}
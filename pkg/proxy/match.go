@@ -0,0 +1,492 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Path pattern kinds in increasing precedence. When several rules match a
+// request the highest kind wins, then the more specific pattern (more
+// literal characters, or the longer expression for regexes), then the rule
+// listed first.
+const (
+	patternAny    = iota // empty path: every request
+	patternPrefix        // "prefix:/api" matches /api and /api/...
+	patternRegex         // "regex:^/orders/[0-9]+$"
+	patternGlob          // "/orders/*", "/users/{id}/cart", "/static/**"
+	patternExact         // "/login"
+)
+
+// pathPattern is a compiled ChaosRule.Path
+type pathPattern struct {
+	kind    int
+	literal string
+	re      *regexp.Regexp
+	score   int // literal characters, used to prefer more specific patterns
+}
+
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// compilePathPattern parses a rule path:
+//
+//	""                  any path
+//	/login              exact match
+//	/orders/*           '*' matches within one segment
+//	/static/**          '**' matches any number of segments
+//	/users/{id}/cart    '{name}' matches one segment and captures it
+//	prefix:/api         /api itself or anything below it
+//	regex:^/v[12]/      Go regular expression
+func compilePathPattern(p string) (*pathPattern, error) {
+	switch {
+	case p == "":
+		return &pathPattern{kind: patternAny}, nil
+
+	case strings.HasPrefix(p, "prefix:"):
+		prefix := strings.TrimSuffix(strings.TrimPrefix(p, "prefix:"), "/")
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("prefix %q must start with '/'", prefix)
+		}
+		return &pathPattern{kind: patternPrefix, literal: prefix, score: len(prefix)}, nil
+
+	case strings.HasPrefix(p, "regex:"):
+		expr := strings.TrimPrefix(p, "regex:")
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", expr, err)
+		}
+		return &pathPattern{kind: patternRegex, re: re, score: len(expr)}, nil
+	}
+
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("path %q must start with '/' (or use prefix: or regex:)", p)
+	}
+	if !strings.ContainsAny(p, "*{}") {
+		return &pathPattern{kind: patternExact, literal: p, score: len(p)}, nil
+	}
+	return compileGlob(p)
+}
+
+// compileGlob turns a glob/parameter pattern into an anchored regexp
+func compileGlob(p string) (*pathPattern, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	score := 0
+	seen := map[string]bool{}
+	for i := 0; i < len(p); {
+		switch {
+		case strings.HasPrefix(p[i:], "**"):
+			b.WriteString(".*")
+			i += 2
+		case p[i] == '*':
+			b.WriteString("[^/]*")
+			i++
+		case p[i] == '{':
+			end := strings.IndexByte(p[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed '{'", p)
+			}
+			name := p[i+1 : i+end]
+			if !paramName.MatchString(name) {
+				return nil, fmt.Errorf("path %q has invalid parameter name %q", p, name)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("path %q repeats parameter %q", p, name)
+			}
+			seen[name] = true
+			fmt.Fprintf(&b, "(?P<%s>[^/]+)", name)
+			i += end + 1
+		case p[i] == '}':
+			return nil, fmt.Errorf("path %q has an unmatched '}'", p)
+		default:
+			b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+			score++
+			i++
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid path pattern %q: %w", p, err)
+	}
+	return &pathPattern{kind: patternGlob, re: re, score: score}, nil
+}
+
+func (p *pathPattern) match(path string) bool {
+	switch p.kind {
+	case patternAny:
+		return true
+	case patternExact:
+		return path == p.literal
+	case patternPrefix:
+		return p.literal == "" || path == p.literal || strings.HasPrefix(path, p.literal+"/")
+	default:
+		return p.re.MatchString(path)
+	}
+}
+
+// params returns the named captures of a glob or regex pattern for path
+func (p *pathPattern) params(path string) map[string]string {
+	if p.re == nil {
+		return nil
+	}
+	m := p.re.FindStringSubmatch(path)
+	if m == nil {
+		return nil
+	}
+	params := make(map[string]string)
+	for i, name := range p.re.SubexpNames() {
+		if name != "" {
+			params[name] = m[i]
+		}
+	}
+	return params
+}
+
+// outranks reports whether p should win over other when both match
+func (p *pathPattern) outranks(other *pathPattern) bool {
+	if p.kind != other.kind {
+		return p.kind > other.kind
+	}
+	return p.score > other.score
+}
+
+// maxMatchBody caps how much of a request body is buffered for body matchers
+const maxMatchBody = 1 << 20
+
+// RequestMatch narrows a rule to requests with specific headers, query
+// parameters, cookies or JSON body fields. Every listed condition must hold.
+// Values match exactly, "*" means present with any value, and a "regex:"
+// prefix matches a Go regular expression.
+type RequestMatch struct {
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Query   map[string]string `json:"query,omitempty" yaml:"query,omitempty"`
+	Cookies map[string]string `json:"cookies,omitempty" yaml:"cookies,omitempty"`
+	Body    []BodyMatch       `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// BodyMatch selects a field of a JSON request body with a path like
+// "$.customer.tier" or "items[0].sku" and matches its value
+type BodyMatch struct {
+	Path  string `json:"path" yaml:"path"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// valueMatcher is a compiled match value
+type valueMatcher struct {
+	exact string
+	any   bool
+	re    *regexp.Regexp
+}
+
+func compileValue(v string) (valueMatcher, error) {
+	switch {
+	case v == "*":
+		return valueMatcher{any: true}, nil
+	case strings.HasPrefix(v, "regex:"):
+		re, err := regexp.Compile(strings.TrimPrefix(v, "regex:"))
+		if err != nil {
+			return valueMatcher{}, fmt.Errorf("invalid regex %q: %w", v, err)
+		}
+		return valueMatcher{re: re}, nil
+	}
+	return valueMatcher{exact: v}, nil
+}
+
+func (m valueMatcher) match(v string) bool {
+	switch {
+	case m.any:
+		return true
+	case m.re != nil:
+		return m.re.MatchString(v)
+	}
+	return v == m.exact
+}
+
+type fieldMatcher struct {
+	name  string
+	value valueMatcher
+}
+
+type bodyMatcher struct {
+	steps []any // string keys and int indexes
+	value valueMatcher
+}
+
+// requestMatcher is a compiled RequestMatch
+type requestMatcher struct {
+	headers []fieldMatcher
+	query   []fieldMatcher
+	cookies []fieldMatcher
+	body    []bodyMatcher
+}
+
+// compile validates the match block and returns its compiled form
+func (m *RequestMatch) compile() (*requestMatcher, error) {
+	var errs []error
+	out := &requestMatcher{}
+	fields := func(kind string, in map[string]string, canon func(string) string) []fieldMatcher {
+		var fms []fieldMatcher
+		for name, v := range in {
+			if strings.TrimSpace(name) == "" {
+				errs = append(errs, fmt.Errorf("match.%s must not contain an empty name", kind))
+				continue
+			}
+			vm, err := compileValue(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("match.%s[%s]: %w", kind, name, err))
+				continue
+			}
+			fms = append(fms, fieldMatcher{name: canon(name), value: vm})
+		}
+		return fms
+	}
+	same := func(s string) string { return s }
+	out.headers = fields("headers", m.Headers, http.CanonicalHeaderKey)
+	out.query = fields("query", m.Query, same)
+	out.cookies = fields("cookies", m.Cookies, same)
+	for i, b := range m.Body {
+		steps, err := parseJSONPath(b.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("match.body[%d]: %w", i, err))
+			continue
+		}
+		vm, err := compileValue(b.Value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("match.body[%d]: %w", i, err))
+			continue
+		}
+		out.body = append(out.body, bodyMatcher{steps: steps, value: vm})
+	}
+	return out, errors.Join(errs...)
+}
+
+// conditions counts the checks, used to prefer more targeted rules
+func (m *requestMatcher) conditions() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.headers) + len(m.query) + len(m.cookies) + len(m.body)
+}
+
+func (m *requestMatcher) match(req *matchRequest) bool {
+	if m == nil {
+		return true
+	}
+	for _, f := range m.headers {
+		if !anyMatch(f.value, req.r.Header.Values(f.name)) {
+			return false
+		}
+	}
+	if len(m.query) > 0 {
+		q := req.r.URL.Query()
+		for _, f := range m.query {
+			if !anyMatch(f.value, q[f.name]) {
+				return false
+			}
+		}
+	}
+	for _, f := range m.cookies {
+		c, err := req.r.Cookie(f.name)
+		if err != nil || !f.value.match(c.Value) {
+			return false
+		}
+	}
+	if len(m.body) > 0 {
+		body, ok := req.jsonBody()
+		if !ok {
+			return false
+		}
+		for _, b := range m.body {
+			v, found := lookupJSON(body, b.steps)
+			if !found || !b.value.match(v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func anyMatch(m valueMatcher, values []string) bool {
+	for _, v := range values {
+		if m.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRequest wraps a request during matching so the body is read at most once
+type matchRequest struct {
+	r        *http.Request
+	bodyRead bool
+	body     any
+	bodyOK   bool
+}
+
+// jsonBody parses the request body as JSON and puts the bytes back so the
+// request can still be proxied
+func (req *matchRequest) jsonBody() (any, bool) {
+	if req.bodyRead {
+		return req.body, req.bodyOK
+	}
+	req.bodyRead = true
+	r := req.r
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, false
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxMatchBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) > maxMatchBody {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&req.body); err != nil {
+		return nil, false
+	}
+	req.bodyOK = true
+	return req.body, true
+}
+
+// parseJSONPath accepts "$.a.b", "a.b[0].c" and "$['a']" style paths
+func parseJSONPath(p string) ([]any, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(p), "$")
+	if s == "" {
+		return nil, fmt.Errorf("invalid JSON path %q", p)
+	}
+	var steps []any
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", p)
+			}
+			steps = append(steps, s[:end])
+			s = s[end:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: unclosed '['", p)
+			}
+			inner := s[1:end]
+			if n, err := strconv.Atoi(inner); err == nil && n >= 0 {
+				steps = append(steps, n)
+			} else if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, inner[1:len(inner)-1])
+			} else {
+				return nil, fmt.Errorf("invalid JSON path %q: bad index %q", p, inner)
+			}
+			s = s[end+1:]
+		default:
+			if len(steps) > 0 {
+				return nil, fmt.Errorf("invalid JSON path %q", p)
+			}
+			s = "." + s // leading key without "$."
+		}
+	}
+	return steps, nil
+}
+
+// walkJSON follows steps through a decoded JSON value
+func walkJSON(v any, steps []any) (any, bool) {
+	for _, step := range steps {
+		switch key := step.(type) {
+		case string:
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[key]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := v.([]any)
+			if !ok || key >= len(arr) {
+				return nil, false
+			}
+			v = arr[key]
+		}
+	}
+	return v, true
+}
+
+// lookupJSON walks steps through a decoded JSON value and renders the result as text
+func lookupJSON(v any, steps []any) (string, bool) {
+	v, ok := walkJSON(v, steps)
+	if !ok {
+		return "", false
+	}
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case bool:
+		return strconv.FormatBool(t), true
+	case nil:
+		return "null", true
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// clientKey identifies the client a request belongs to, for per-client
+// state. spec is "" (one shared key), "ip", "header:<name>",
+// "cookie:<name>" or "query:<name>".
+func clientKey(r *http.Request, spec string) string {
+	kind, name, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "ip":
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	case "header":
+		return r.Header.Get(name)
+	case "cookie":
+		if c, err := r.Cookie(name); err == nil {
+			return c.Value
+		}
+		return ""
+	case "query":
+		return r.URL.Query().Get(name)
+	}
+	return ""
+}
+
+func validateClientKey(spec string) error {
+	kind, name, hasName := strings.Cut(spec, ":")
+	switch kind {
+	case "", "ip":
+		if hasName {
+			return fmt.Errorf("invalid key %q", spec)
+		}
+		return nil
+	case "header", "cookie", "query":
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("key %q needs a name, e.g. %s:X-Api-Key", spec, kind)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown key %q (use ip, header:<name>, cookie:<name> or query:<name>)", spec)
+}
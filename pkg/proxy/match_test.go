@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompilePathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"", "/anything/at/all", true},
+		{"/login", "/login", true},
+		{"/login", "/login/", false},
+		{"/login", "/", false},
+		{"/", "/", true},
+		{"/", "/login", false},
+		{"/orders/*", "/orders/42", true},
+		{"/orders/*", "/orders/", true},
+		{"/orders/*", "/orders/42/items", false},
+		{"/static/**", "/static/css/site.css", true},
+		{"/static/**", "/static/", true},
+		{"/static/**", "/other/site.css", false},
+		{"/users/{id}/cart", "/users/7/cart", true},
+		{"/users/{id}/cart", "/users//cart", false},
+		{"/users/{id}/cart", "/users/7/8/cart", false},
+		{"/files/a.b", "/files/aXb", false},
+		{"prefix:/api", "/api", true},
+		{"prefix:/api", "/api/v1/users", true},
+		{"prefix:/api", "/apiv1", false},
+		{"prefix:/api/", "/api/x", true},
+		{"prefix:", "/anything", true},
+		{"regex:^/v[12]/", "/v1/users", true},
+		{"regex:^/v[12]/", "/v3/users", false},
+	}
+	for _, tt := range tests {
+		p, err := compilePathPattern(tt.pattern)
+		if err != nil {
+			t.Errorf("compilePathPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := p.match(tt.path); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompilePathPatternErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"login",
+		"prefix:api",
+		"regex:(",
+		"/users/{id",
+		"/users/id}",
+		"/users/{}",
+		"/users/{1d}",
+		"/a/{id}/b/{id}",
+	} {
+		if _, err := compilePathPattern(pattern); err == nil {
+			t.Errorf("compilePathPattern(%q): expected an error", pattern)
+		}
+	}
+}
+
+func TestPathPatternParams(t *testing.T) {
+	p, err := compilePathPattern("/users/{id}/orders/{order}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := p.params("/users/7/orders/x-1")
+	if len(got) != 2 || got["id"] != "7" || got["order"] != "x-1" {
+		t.Errorf("params = %v, want id=7 order=x-1", got)
+	}
+	if got := p.params("/users/7"); got != nil {
+		t.Errorf("params of a non-matching path = %v, want nil", got)
+	}
+}
+
+func TestPathPatternOutranks(t *testing.T) {
+	tests := []struct {
+		winner, loser string
+	}{
+		{"/orders/42", "/orders/*"},
+		{"/orders/*", "regex:^/orders/[0-9]+$"},
+		{"regex:^/orders/", "prefix:/orders"},
+		{"prefix:/orders", ""},
+		{"/orders/{id}/items", "/orders/**"},
+		{"prefix:/orders/items", "prefix:/orders"},
+		{"regex:^/orders/[0-9]+$", "regex:^/orders/"},
+	}
+	for _, tt := range tests {
+		w, err := compilePathPattern(tt.winner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := compilePathPattern(tt.loser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !w.outranks(l) {
+			t.Errorf("%q should outrank %q", tt.winner, tt.loser)
+		}
+		if l.outranks(w) {
+			t.Errorf("%q should not outrank %q", tt.loser, tt.winner)
+		}
+	}
+}
+
+func TestFindMatchingRulePrecedence(t *testing.T) {
+	rules := []ChaosRule{
+		{ID: "any", Path: ""},
+		{ID: "prefix", Path: "prefix:/orders"},
+		{ID: "glob", Path: "/orders/*"},
+		{ID: "exact", Path: "/orders/42"},
+		{ID: "header", Path: "/orders/*", Match: &RequestMatch{Headers: map[string]string{"X-Tier": "gold"}}},
+	}
+	cp, err := NewChaosProxy("http://localhost:1", 0, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, tier, want string
+	}{
+		{"/orders/42", "", "exact"},
+		{"/orders/7", "", "glob"},
+		{"/orders/7", "gold", "header"},
+		{"/orders/7/items", "", "prefix"},
+		{"/login", "", "any"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.tier != "" {
+			r.Header.Set("X-Tier", tt.tier)
+		}
+		rule, _ := cp.findMatchingRule(r)
+		if rule == nil || rule.ID != tt.want {
+			t.Errorf("%s (tier %q) matched %v, want %s", tt.path, tt.tier, rule, tt.want)
+		}
+	}
+}
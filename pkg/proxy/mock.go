@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/template"
+)
+
+// MockSpec answers matching requests itself, so the backend is never
+// contacted. The body comes from Body or BodyFile; with Template set it is
+// a Go text/template rendered with mockRequest.
+type MockSpec struct {
+	Status      int               `json:"status,omitempty" yaml:"status,omitempty"` // default 200
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	ContentType string            `json:"content_type,omitempty" yaml:"content_type,omitempty"` // default application/json
+	Body        string            `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyFile    string            `json:"body_file,omitempty" yaml:"body_file,omitempty"` // read when the rules are loaded
+	Template    bool              `json:"template,omitempty" yaml:"template,omitempty"`
+
+	body []byte             // Body or the contents of BodyFile
+	tmpl *template.Template // compiled body when Template is set
+}
+
+// mockRequest is the data available to mock templates, e.g.
+// {{.Params.id}}, {{.Query.Get "page"}}, {{.Header.Get "X-User"}} or {{.JSON.name}}
+type mockRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Params map[string]string // captures from {name} path patterns and regex groups
+	Body   string
+	JSON   any // the body decoded as JSON, nil if it is not JSON
+}
+
+// Validate reports invalid mock settings, reads BodyFile and compiles the template
+func (m *MockSpec) Validate() error {
+	var errs []error
+	if m.Status != 0 && (m.Status < 100 || m.Status > 599) {
+		errs = append(errs, fmt.Errorf("mock.status must be between 100 and 599, got %d", m.Status))
+	}
+	m.body = []byte(m.Body)
+	if m.BodyFile != "" {
+		if m.Body != "" {
+			errs = append(errs, errors.New("use either mock.body or mock.body_file, not both"))
+		}
+		data, err := os.ReadFile(m.BodyFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mock.body_file: %w", err))
+		}
+		m.body = data
+	}
+	m.tmpl = nil
+	if m.Template {
+		t, err := template.New("mock").Option("missingkey=zero").Parse(string(m.body))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mock template: %w", err))
+		}
+		m.tmpl = t
+	}
+	return errors.Join(errs...)
+}
+
+// write sends the mock response and returns its status
+func (m *MockSpec) write(w http.ResponseWriter, r *http.Request, path *pathPattern) int {
+	body := m.body
+	if m.tmpl != nil {
+		var buf bytes.Buffer
+		if err := m.tmpl.Execute(&buf, newMockRequest(r, path)); err != nil {
+			http.Error(w, "chaos: mock template: "+err.Error(), http.StatusInternalServerError)
+			return http.StatusInternalServerError
+		}
+		body = buf.Bytes()
+	}
+
+	status := m.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := m.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	for k, v := range m.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+	return status
+}
+
+func newMockRequest(r *http.Request, path *pathPattern) *mockRequest {
+	req := &mockRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+		Params: path.params(r.URL.Path),
+	}
+	if r.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxMatchBody))
+		req.Body = string(body)
+		_ = json.Unmarshal(body, &req.JSON)
+	}
+	return req
+}
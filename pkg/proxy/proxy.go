@@ -2,13 +2,16 @@ package proxy
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
-	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -25,12 +28,20 @@ import (
 // }
 
 type ChaosProxy struct {
-	TargetURL *url.URL
-	Port      int
-	Rules     []ChaosRule
-	proxy     *httputil.ReverseProxy
-	Metrics   *metrics.MetricsCollector
-	server    *http.Server
+	TargetURL   *url.URL
+	Port        int
+	AdminPort   int    // serves the rules admin API when > 0
+	AdminAddr   string // interface for the admin API, 127.0.0.1 by default; "" listens on all
+	MetricsPort int    // serves Prometheus metrics at /metrics when > 0
+	Rules       *RuleStore
+	Rand        *Rand // source for every chaos decision; replace before Start to fix the seed
+	proxy       *httputil.ReverseProxy
+	Metrics     *metrics.MetricsCollector
+	Live        *metrics.Prometheus // live counters for MetricsPort
+	server      *http.Server
+	stopping    chan struct{} // closed when shutdown starts, releases hanging requests
+	stopOnce    sync.Once
+	started     time.Time // schedules of rules loaded before start are measured from here
 }
 
 // NewChaosProxy creates a configured proxy
@@ -39,16 +50,53 @@ func NewChaosProxy(target string, port int, rules []ChaosRule) (*ChaosProxy, err
 	if err != nil {
 		return nil, err
 	}
+	store, err := NewRuleStore(rules)
+	if err != nil {
+		return nil, err
+	}
 	rp := httputil.NewSingleHostReverseProxy(u)
 
 	cp := &ChaosProxy{
 		TargetURL: u,
 		Port:      port,
-		Rules:     rules,
+		AdminAddr: "127.0.0.1",
+		Rules:     store,
+		Rand:      NewRand(NewSeed()),
 		proxy:     rp,
 		Metrics:   metrics.New(),
+		Live:      metrics.NewPrometheus(),
+		stopping:  make(chan struct{}),
+		started:   time.Now(),
 	}
-	// keep default director, but you can override if needed
+	rp.ModifyResponse = cp.modifyResponse
+	rp.ErrorHandler = cp.proxyError
+	// wrap the default director so request faults apply to the outgoing request
+	director := rp.Director
+	rp.Director = func(out *http.Request) {
+		director(out)
+		if id, _ := out.Context().Value(requestIDKey{}).(string); id != "" {
+			out.Header.Set(requestIDHeader, id)
+		}
+		if spec, _ := out.Context().Value(requestChaosKey{}).(*RequestSpec); spec != nil {
+			cp.mutateRequest(out, spec)
+		}
+		if rc, _ := out.Context().Value(responseChaosKey{}).(*responseChaos); rc != nil && rc.timeout != nil {
+			rc.timer = rc.timeout.start(rc.cancel)
+		}
+		if t, _ := out.Context().Value(upstreamTimingKey{}).(*upstreamTiming); t != nil {
+			t.sent = time.Now()
+		}
+	}
+
+	current, version := store.Snapshot()
+	cp.Metrics.RecordEvent(metrics.Event{
+		Timestamp: time.Now(),
+		Event:     "rules_loaded",
+		RuleSet:   version,
+		Source:    "startup",
+		Rules:     ruleIDs(current),
+	})
+	store.OnChange(cp.recordRuleSetChange)
 	return cp, nil
 }
 
@@ -57,26 +105,55 @@ func (cp *ChaosProxy) StartWithCtx(ctx context.Context) error {
 	mux.Handle("/", cp)
 
 	addr := fmt.Sprintf(":%d", cp.Port)
+	cp.started = time.Now()
 	cp.server = &http.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
 
 	// run server in goroutine
-	errCh := make(chan error, 1)
+	errCh := make(chan error, 3)
 	go func() {
 		errCh <- cp.server.ListenAndServe()
 	}()
 
+	// admin API and metrics run on their own listeners so they never touch proxied traffic
+	var aux []*http.Server
+	serve := func(name, host string, port int, h http.Handler) {
+		srv := &http.Server{Addr: net.JoinHostPort(host, strconv.Itoa(port)), Handler: h}
+		aux = append(aux, srv)
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errCh <- fmt.Errorf("%s server: %w", name, err)
+			}
+		}()
+	}
+	if cp.AdminPort > 0 {
+		serve("admin", cp.AdminAddr, cp.AdminPort, AdminHandler(cp.Rules))
+	}
+	if cp.MetricsPort > 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", cp.Live)
+		serve("metrics", "", cp.MetricsPort, metricsMux)
+	}
+
 	// listen for ctx done or signal
 	select {
 	case <-ctx.Done():
 		// shutdown triggered by caller
+		cp.stopOnce.Do(func() { close(cp.stopping) })
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
+		for _, srv := range aux {
+			_ = srv.Shutdown(shutdownCtx)
+		}
 		return cp.server.Shutdown(shutdownCtx)
 	case err := <-errCh:
 		// server returned an error
+		for _, srv := range aux {
+			_ = srv.Close()
+		}
+		_ = cp.server.Close()
 		return err
 	}
 }
@@ -99,84 +176,312 @@ func (cp *ChaosProxy) Start() error {
 	return cp.StartWithCtx(ctx)
 }
 
-// findMatchingRule finds the first matching rule for path+method
-func (cp *ChaosProxy) findMatchingRule(path, method string) *ChaosRule {
-	for i := range cp.Rules {
-		r := &cp.Rules[i]
-		if r.Path != "" && r.Path != path {
+// findMatchingRule picks the most specific active rule (not paused, inside
+// its schedule) matching the request (see the pattern kinds in match.go) and
+// returns it with the version of the rule set it came from
+func (cp *ChaosProxy) findMatchingRule(r *http.Request) (*ChaosRule, int) {
+	rules, version := cp.Rules.Snapshot()
+	now := time.Now()
+	req := &matchRequest{r: r}
+	var best *ChaosRule
+	for _, rule := range rules {
+		if !rule.active(now, rule.elapsed(now, cp.started)) || !rule.matches(req) {
 			continue
 		}
-		if r.Method != "" && r.Method != method {
-			continue
+		if best == nil || rule.outranks(best) {
+			best = rule
 		}
-		return r
 	}
-	return nil
+	return best, version
 }
 
 func (cp *ChaosProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	chaosApplied := false
+	// every response goes through rec so its bytes are counted
+	rec := NewStatusRecorder(w)
+	w = rec
+	r, requestID := withRequestID(r)
+	// chaosType is the failure injected, if any; degradation is chaos that
+	// still lets the response through (delay, throttle) and is reported
+	// when no failure happened
 	chaosType := "none"
+	degradation := "none"
 	backendErr := false
+	var cut *cutWriter
+	var rc *responseChaos
+	var delay time.Duration
+	var timing *upstreamTiming
 
 	// Check rule
-	rule := cp.findMatchingRule(r.URL.Path, r.Method)
+	rule, ruleSet := cp.findMatchingRule(r)
+	// count the body after matching, which puts back what it buffered
+	var body *bodyCounter
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &bodyCounter{ReadCloser: r.Body}
+		r.Body = body
+	}
+	// live metrics group requests by the matched rule's pattern when it
+	// covers many paths; exact and catch-all rules keep the raw path
+	ruleID, route := "", ""
 	if rule != nil {
-		// Delay
-		if rule.Delay > 0 {
-			chaosApplied = true
-			chaosType = "delay"
-			time.Sleep(rule.Delay)
-		}
-		// Random fail
-		if rule.FailureRate > 0 && rand.Float64() < rule.FailureRate {
-			chaosApplied = true
-			chaosType = "failure"
-			status := rule.StatusCode
-			if status == 0 {
-				status = http.StatusServiceUnavailable
+		ruleID = rule.ID
+		switch rule.path.kind {
+		case patternPrefix, patternRegex, patternGlob:
+			route = rule.Path
+		}
+	}
+	// clients outside a rule's cohort pass through untouched
+	cohort := ""
+	if rule != nil && rule.Cohort != nil {
+		cohort = "out"
+		if rule.Cohort.contains(r) {
+			cohort = "in"
+		} else {
+			rule = nil
+		}
+	}
+	record := func(status int) {
+		if rc != nil && rc.headersChanged {
+			degradation = "response_headers"
+		}
+		if rc != nil && rc.corrupted {
+			degradation = "corrupt"
+		}
+		applied := chaosType
+		if applied == "none" {
+			applied = degradation
+		}
+		latency := time.Since(start)
+		m := metrics.RequestMetric{
+			Timestamp:      time.Now(),
+			RequestID:      requestID,
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			Query:          r.URL.RawQuery,
+			ClientAddr:     r.RemoteAddr,
+			StatusCode:     status,
+			LatencyMs:      latency.Milliseconds(),
+			UpstreamMs:     timing.elapsed().Milliseconds(),
+			DelayMs:        delay.Milliseconds(),
+			BytesOut:       rec.Written,
+			ChaosApplied:   applied != "none",
+			ChaosType:      applied,
+			BackendError:   backendErr,
+			GatewayTimeout: rc != nil && rc.timedOut,
+			RuleSet:        ruleSet,
+			Rule:           ruleID,
+			Cohort:         cohort,
+		}
+		if body != nil {
+			m.BytesIn = body.n.Load()
+		}
+		cp.Metrics.RecordRequest(m)
+		cp.Live.Observe(m, route, latency)
+	}
+
+	if rule != nil {
+		intensity := rule.intensity(rule.elapsed(time.Now(), cp.started))
+		// Rate limit before anything else so rejections stay fast
+		if rule.RateLimit != nil {
+			if ok, wait := rule.state.allow(rule.RateLimit, clientKey(r, rule.RateLimit.Key), time.Now()); !ok {
+				chaosType = "rate_limit"
+				record(writeRateLimited(w, rule.RateLimit, wait))
+				return
 			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(status)
-			if rule.ErrorBody != "" {
-				_, _ = w.Write([]byte(rule.ErrorBody))
-			} else {
-				_, _ = w.Write([]byte(`{"error":"chaos injected"}`))
+		}
+		// Delay
+		if delay = rule.sampleDelay(cp.Rand, intensity); delay > 0 {
+			degradation = "delay"
+			time.Sleep(delay)
+		}
+		// Fail by chance, or by count for sequences
+		if fault, fail := rule.failure(r, cp.Rand, intensity); fail {
+			switch fault {
+			case "", FaultStatus:
+				chaosType = "failure"
+				record(writeFailure(w, rule, cp.Rand))
+				return
+			case FaultCloseBody:
+				// needs the upstream response, handled below
+				cut = &cutWriter{limit: rule.CutAfter}
+			default:
+				// status 0: the client never got an HTTP response
+				chaosType = fault
+				abort := cp.injectConnectionFault(w, r, rule, fault)
+				record(0)
+				if abort {
+					panic(http.ErrAbortHandler)
+				}
+				return
 			}
-			lat := time.Since(start).Milliseconds()
-			cp.Metrics.RecordRequest(metrics.RequestMetric{
-				Timestamp:    time.Now(),
-				Method:       r.Method,
-				Path:         r.URL.Path,
-				StatusCode:   status,
-				LatencyMs:    lat,
-				ChaosApplied: true,
-				ChaosType:    chaosType,
-				BackendError: false,
-			})
+		}
+	}
+
+	// Mock: answer from the rule instead of the backend
+	if rule != nil && rule.Mock != nil {
+		chaosType = "mock"
+		record(rule.Mock.write(w, r, rule.path))
+		return
+	}
+
+	// Request faults: answer without contacting the backend, or change what it receives
+	if rule != nil && rule.Request != nil && cp.Rand.chance(rule.Request.Rate) {
+		if rule.Request.Drop {
+			chaosType = "drop"
+			record(writeDropped(w, rule.Request))
 			return
 		}
+		degradation = rule.Request.chaosType()
+		r = r.WithContext(context.WithValue(r.Context(), requestChaosKey{}, rule.Request))
 	}
 
-	// Wrap ResponseWriter to capture status
-	rec := NewStatusRecorder(w)
-	cp.proxy.ServeHTTP(rec, r)
+	var out http.ResponseWriter = rec
+	if rule != nil && rule.Throttle != nil && cp.Rand.chance(rule.Throttle.Rate) {
+		degradation = "throttle"
+		throttleRequest(r, rule.Throttle)
+		out = newThrottleWriter(out, r, rule.Throttle)
+	}
+	if rule != nil && rule.Corrupt != nil && cp.Rand.chance(rule.Corrupt.Rate) {
+		rc = &responseChaos{corrupt: rule.Corrupt}
+	}
+	if rule != nil && rule.ResponseHeaders != nil && cp.Rand.chance(rule.ResponseHeaders.Rate) {
+		if rc == nil {
+			rc = &responseChaos{}
+		}
+		rc.headers = rule.ResponseHeaders
+	}
+	if rule != nil && rule.UpstreamTimeout != nil {
+		if rc == nil {
+			rc = &responseChaos{}
+		}
+		// the timer starts in the director, once request faults (including
+		// duplicates) are done, so only the backend's answer counts
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		defer rc.stopTimer()
+		rc.timeout, rc.cancel = rule.UpstreamTimeout, cancel
+		r = r.WithContext(ctx)
+	}
+	if rc != nil {
+		r = r.WithContext(context.WithValue(r.Context(), responseChaosKey{}, rc))
+	}
+	timing = &upstreamTiming{}
+	r = r.WithContext(context.WithValue(r.Context(), upstreamTimingKey{}, timing))
+	if cut != nil {
+		cut.ResponseWriter = out
+		out = cut
+	}
+	// the reverse proxy panics with ErrAbortHandler when the body copy fails,
+	// after a close_body cut or when the client gives up mid-transfer (as in
+	// a throttled download), so record what was sent before passing it on
+	defer func() {
+		if p := recover(); p != nil {
+			if cut != nil && cut.cut {
+				chaosType = FaultCloseBody
+			}
+			record(rec.StatusCode)
+			panic(p)
+		}
+	}()
+	cp.proxy.ServeHTTP(out, r)
 
-	// Determine backend error (5xx)
-	if rec.StatusCode >= 500 {
+	// Determine backend error (5xx); a gateway timeout is the proxy's own answer
+	if rc != nil && rc.timedOut {
+		chaosType = "gateway_timeout"
+	} else if rec.StatusCode >= 500 {
 		backendErr = true
 	}
+	// a body shorter than the cut point reaches the client intact
+	if cut != nil && cut.cut {
+		chaosType = FaultCloseBody
+	}
 
-	lat := time.Since(start).Milliseconds()
-	cp.Metrics.RecordRequest(metrics.RequestMetric{
-		Timestamp:    time.Now(),
-		Method:       r.Method,
-		Path:         r.URL.Path,
-		StatusCode:   rec.StatusCode,
-		LatencyMs:    lat,
-		ChaosApplied: chaosApplied,
-		ChaosType:    chaosType,
-		BackendError: backendErr,
-	})
+	record(rec.StatusCode)
+}
+
+// requestIDHeader carries the ID that ties a metric to backend logs
+const requestIDHeader = "X-Request-Id"
+
+// requestIDKey carries a generated request ID to the director, which sets
+// it on the outgoing request only
+type requestIDKey struct{}
+
+// withRequestID returns the client's request ID, or a new one that the
+// director forwards to the backend; the client's headers are left alone. IDs
+// come from crypto/rand so they never consume the seeded chaos randomness.
+func withRequestID(r *http.Request) (*http.Request, string) {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return r, id
+	}
+	id := rand.Text()
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)), id
+}
+
+// responseChaos carries per-request decisions from ServeHTTP to
+// modifyResponse through the request context
+type responseChaos struct {
+	corrupt        *CorruptSpec
+	corrupted      bool
+	headers        *ResponseHeadersSpec
+	headersChanged bool
+	timeout        *UpstreamTimeoutSpec
+	cancel         context.CancelCauseFunc
+	timer          *time.Timer // started by the director, stopped once response headers arrive
+	timedOut       bool
+}
+
+// stopTimer stops the upstream timeout, if it was started
+func (rc *responseChaos) stopTimer() {
+	if rc.timer != nil {
+		rc.timer.Stop()
+	}
+}
+
+type responseChaosKey struct{}
+
+// upstreamTiming measures how long the backend took to answer: from sending
+// the request (after any request faults) until the response headers arrived
+// or the round trip failed
+type upstreamTiming struct {
+	sent     time.Time
+	duration time.Duration
+}
+
+type upstreamTimingKey struct{}
+
+// stopUpstreamTiming stops the clock for the request's upstream timing, if it has one
+func stopUpstreamTiming(r *http.Request) {
+	if t, _ := r.Context().Value(upstreamTimingKey{}).(*upstreamTiming); t != nil && !t.sent.IsZero() && t.duration == 0 {
+		t.duration = time.Since(t.sent)
+	}
+}
+
+// elapsed is the measured upstream time, 0 when the backend was not contacted
+func (t *upstreamTiming) elapsed() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return t.duration
+}
+
+// modifyResponse applies response mutations chosen in ServeHTTP
+func (cp *ChaosProxy) modifyResponse(resp *http.Response) error {
+	stopUpstreamTiming(resp.Request)
+	rc, _ := resp.Request.Context().Value(responseChaosKey{}).(*responseChaos)
+	if rc == nil {
+		return nil
+	}
+	rc.stopTimer()
+	if rc.corrupt != nil {
+		ok, err := rc.corrupt.apply(resp, cp.Rand)
+		if err != nil {
+			return err
+		}
+		rc.corrupted = ok
+	}
+	if rc.headers != nil {
+		rc.headers.apply(resp.Header)
+		rc.headersChanged = true
+	}
+	return nil
 }
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Rand is a seeded random source safe for concurrent use. Every chaos
+// decision for a proxy draws from one Rand, so the same seed and the same
+// sequence of requests reproduce the same faults.
+type Rand struct {
+	mu   sync.Mutex
+	seed int64
+	r    *rand.Rand
+}
+
+// NewRand returns a source seeded with seed
+func NewRand(seed int64) *Rand {
+	return &Rand{seed: seed, r: rand.New(rand.NewSource(seed))}
+}
+
+// NewSeed picks a seed for runs that did not ask for one
+func NewSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+// Seed returns the seed the source was created with
+func (r *Rand) Seed() int64 {
+	return r.seed
+}
+
+func (r *Rand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+func (r *Rand) NormFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.NormFloat64()
+}
+
+func (r *Rand) ExpFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.ExpFloat64()
+}
+
+func (r *Rand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+// chance reports whether an event with probability p happens; p of 0 means
+// always, matching the Rate fields on the fault specs
+func (r *Rand) chance(p float64) bool {
+	return p == 0 || r.Float64() < p
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// maxBucketKeys bounds per-client buckets. At the bound, full (idle) buckets
+// are dropped, then the least recently used ones down to bucketKeysAfterEvict,
+// so the map is scanned once per batch of new clients rather than per request.
+const (
+	maxBucketKeys        = 10000
+	bucketKeysAfterEvict = maxBucketKeys * 9 / 10
+)
+
+// RateLimitSpec enforces a token bucket per rule, or per client when Key is
+// set. Requests beyond the limit get Status (default 429) with Retry-After.
+type RateLimitSpec struct {
+	Requests int      `json:"requests" yaml:"requests"`
+	Per      Duration `json:"per,omitempty" yaml:"per,omitempty"`     // default 1s
+	Burst    int      `json:"burst,omitempty" yaml:"burst,omitempty"` // default Requests
+	Key      string   `json:"key,omitempty" yaml:"key,omitempty"`     // see clientKey
+	Status   int      `json:"status,omitempty" yaml:"status,omitempty"`
+	Body     string   `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// Validate reports invalid rate limit settings
+func (l *RateLimitSpec) Validate() error {
+	var errs []error
+	if l.Requests <= 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.requests must be greater than 0, got %d", l.Requests))
+	}
+	if l.Per < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.per must not be negative, got %s", l.Per))
+	}
+	if l.Burst < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit.burst must not be negative, got %d", l.Burst))
+	}
+	if l.Status != 0 && (l.Status < 100 || l.Status > 599) {
+		errs = append(errs, fmt.Errorf("rate_limit.status must be between 100 and 599, got %d", l.Status))
+	}
+	if err := validateClientKey(l.Key); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit.key: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// refill returns tokens per second and the bucket capacity
+func (l *RateLimitSpec) refill() (float64, float64) {
+	per := time.Duration(l.Per)
+	if per <= 0 {
+		per = time.Second
+	}
+	burst := l.Burst
+	if burst == 0 {
+		burst = l.Requests
+	}
+	return float64(l.Requests) / per.Seconds(), float64(burst)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token for key, or reports how long until one is available
+func (s *ruleState) allow(l *RateLimitSpec, key string, now time.Time) (bool, time.Duration) {
+	rate, burst := l.refill()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets == nil {
+		s.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= maxBucketKeys {
+			s.evictBuckets(rate, burst, now)
+		}
+		b = &tokenBucket{tokens: burst, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait
+}
+
+// evictBuckets makes room for new keys, must be called with mu held
+func (s *ruleState) evictBuckets(rate, burst float64, now time.Time) {
+	for k, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(s.buckets, k)
+		}
+	}
+	if len(s.buckets) <= bucketKeysAfterEvict {
+		return
+	}
+	keys := make([]string, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return s.buckets[a].last.Compare(s.buckets[b].last)
+	})
+	for _, k := range keys[:len(keys)-bucketKeysAfterEvict] {
+		delete(s.buckets, k)
+	}
+}
+
+// writeRateLimited sends the throttling response and returns its status
+func writeRateLimited(w http.ResponseWriter, l *RateLimitSpec, wait time.Duration) int {
+	status := l.Status
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	body := l.Body
+	if body == "" {
+		body = `{"error":"rate limit exceeded"}`
+	}
+	// Retry-After is whole seconds, rounded up so clients never retry early
+	secs := max(int64(math.Ceil(wait.Seconds())), 1)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+	return status
+}
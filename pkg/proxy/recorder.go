@@ -1,6 +1,10 @@
 package proxy
 
-import "net/http"
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
 
 // StatusRecorder wraps http.ResponseWriter to capture status code and bytes
 type StatusRecorder struct {
@@ -26,3 +30,22 @@ func (r *StatusRecorder) Write(b []byte) (int, error) {
 	r.Written += int64(n)
 	return n, err
 }
+
+// Unwrap exposes the underlying writer to http.ResponseController (flush, hijack)
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// bodyCounter counts the request body bytes the proxy reads from the client.
+// The transport may still be sending the body when the response is done, so
+// the count is atomic.
+type bodyCounter struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (c *bodyCounter) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
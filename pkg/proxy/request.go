@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxRewriteBody caps how much of a request body is buffered to duplicate or
+// rewrite it; larger bodies are forwarded once, untouched
+const maxRewriteBody = 10 << 20
+
+// RequestSpec mutates the request on its way upstream. Drop never contacts
+// the backend and answers the client with a fake success instead; Duplicate
+// sends extra copies first and discards their responses. Rate is the
+// fraction of matching requests affected (0 means all).
+type RequestSpec struct {
+	Drop          bool              `json:"drop,omitempty" yaml:"drop,omitempty"`
+	DropStatus    int               `json:"drop_status,omitempty" yaml:"drop_status,omitempty"` // default 200
+	DropBody      string            `json:"drop_body,omitempty" yaml:"drop_body,omitempty"`
+	Duplicate     int               `json:"duplicate,omitempty" yaml:"duplicate,omitempty"`
+	RemoveHeaders []string          `json:"remove_headers,omitempty" yaml:"remove_headers,omitempty"`
+	SetHeaders    map[string]string `json:"set_headers,omitempty" yaml:"set_headers,omitempty"`
+	Body          *string           `json:"body,omitempty" yaml:"body,omitempty"` // replaces the body, "" empties it
+	Replace       []BodyReplace     `json:"replace,omitempty" yaml:"replace,omitempty"`
+	Rate          float64           `json:"rate,omitempty" yaml:"rate,omitempty"`
+}
+
+// BodyReplace substitutes every occurrence of Old in the request body
+type BodyReplace struct {
+	Old string `json:"old" yaml:"old"`
+	New string `json:"new" yaml:"new"`
+}
+
+// Validate reports invalid request fault settings
+func (s *RequestSpec) Validate() error {
+	var errs []error
+	if s.Drop && (s.Duplicate != 0 || len(s.RemoveHeaders) > 0 || len(s.SetHeaders) > 0 || s.Body != nil || len(s.Replace) > 0) {
+		errs = append(errs, errors.New("request.drop cannot be combined with other request faults"))
+	}
+	if s.DropStatus != 0 && (s.DropStatus < 100 || s.DropStatus > 599) {
+		errs = append(errs, fmt.Errorf("request.drop_status must be between 100 and 599, got %d", s.DropStatus))
+	}
+	if s.Duplicate < 0 {
+		errs = append(errs, fmt.Errorf("request.duplicate must not be negative, got %d", s.Duplicate))
+	}
+	for _, name := range s.RemoveHeaders {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, errors.New("request.remove_headers must not contain an empty name"))
+		}
+	}
+	for name := range s.SetHeaders {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, errors.New("request.set_headers must not contain an empty name"))
+		}
+	}
+	if s.Body != nil && len(s.Replace) > 0 {
+		errs = append(errs, errors.New("use either request.body or request.replace, not both"))
+	}
+	for i, r := range s.Replace {
+		if r.Old == "" {
+			errs = append(errs, fmt.Errorf("request.replace[%d].old must not be empty", i))
+		}
+	}
+	if s.Rate < 0 || s.Rate > 1 {
+		errs = append(errs, fmt.Errorf("request.rate must be between 0.0 and 1.0, got %g", s.Rate))
+	}
+	return errors.Join(errs...)
+}
+
+// chaosType names the fault for metrics
+func (s *RequestSpec) chaosType() string {
+	switch {
+	case s.Drop:
+		return "drop"
+	case s.Duplicate > 0:
+		return "duplicate"
+	}
+	return "mutate_request"
+}
+
+// writeDropped answers for a request that never went upstream
+func writeDropped(w http.ResponseWriter, s *RequestSpec) int {
+	status := s.DropStatus
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := s.DropBody
+	if body == "" {
+		body = `{"status":"ok"}`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+	return status
+}
+
+type requestChaosKey struct{}
+
+// mutateRequest applies s to the outgoing request; it runs in the reverse
+// proxy director after the URL has been rewritten for the target
+func (cp *ChaosProxy) mutateRequest(out *http.Request, s *RequestSpec) {
+	for _, name := range s.RemoveHeaders {
+		out.Header.Del(name)
+	}
+	for k, v := range s.SetHeaders {
+		out.Header.Set(k, v)
+	}
+	if s.Body == nil && len(s.Replace) == 0 && s.Duplicate == 0 {
+		return
+	}
+
+	var body []byte
+	if out.Body != nil && out.Body != http.NoBody {
+		b, err := io.ReadAll(io.LimitReader(out.Body, maxRewriteBody+1))
+		if err != nil || len(b) > maxRewriteBody {
+			// put back what was read and forward the request once as is
+			out.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(b), out.Body), out.Body}
+			return
+		}
+		out.Body.Close()
+		body = b
+	}
+	if s.Body != nil {
+		body = []byte(*s.Body)
+	}
+	for _, r := range s.Replace {
+		body = bytes.ReplaceAll(body, []byte(r.Old), []byte(r.New))
+	}
+	setRequestBody(out, body)
+
+	for range s.Duplicate {
+		dup := out.Clone(out.Context())
+		setRequestBody(dup, body)
+		resp, err := cp.transport().RoundTrip(dup)
+		if err != nil {
+			continue
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// setRequestBody replaces the body and its length headers
+func setRequestBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Transfer-Encoding")
+	r.TransferEncoding = nil
+	if len(body) == 0 {
+		r.Body = http.NoBody
+		r.Header.Del("Content-Length")
+		return
+	}
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// transport is what the reverse proxy uses to reach the target
+func (cp *ChaosProxy) transport() http.RoundTripper {
+	if cp.proxy.Transport != nil {
+		return cp.proxy.Transport
+	}
+	return http.DefaultTransport
+}
@@ -1,12 +1,307 @@
 package proxy
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
 
+// ChaosRule describes which requests to target and what chaos to inject.
+// Field tags define the rules file format (YAML or JSON).
 type ChaosRule struct {
-	Path        string
-	Method      string
-	Delay       time.Duration
-	FailureRate float64
-	StatusCode  int
-	ErrorBody   string
+	ID              string               `json:"id,omitempty" yaml:"id,omitempty"`
+	Path            string               `json:"path,omitempty" yaml:"path,omitempty"`
+	Method          string               `json:"method,omitempty" yaml:"method,omitempty"`
+	Match           *RequestMatch        `json:"match,omitempty" yaml:"match,omitempty"`
+	Cohort          *CohortSpec          `json:"cohort,omitempty" yaml:"cohort,omitempty"` // sticky fraction of clients that get the chaos
+	Delay           Duration             `json:"delay,omitempty" yaml:"delay,omitempty"`
+	Latency         *LatencySpec         `json:"latency,omitempty" yaml:"latency,omitempty"` // random delay, instead of Delay
+	FailureRate     float64              `json:"failure_rate,omitempty" yaml:"failure_rate,omitempty"`
+	Sequence        *SequenceSpec        `json:"sequence,omitempty" yaml:"sequence,omitempty"` // fail by request count, instead of FailureRate
+	Flap            *FlapSpec            `json:"flap,omitempty" yaml:"flap,omitempty"`         // alternating outages, instead of FailureRate
+	StatusCode      int                  `json:"status_code,omitempty" yaml:"status_code,omitempty"`
+	ErrorBody       string               `json:"error_body,omitempty" yaml:"error_body,omitempty"`
+	Headers         map[string]string    `json:"headers,omitempty" yaml:"headers,omitempty"`     // extra headers on injected failures
+	Responses       []FailureResponse    `json:"responses,omitempty" yaml:"responses,omitempty"` // weighted mix, instead of StatusCode/ErrorBody
+	Fault           string               `json:"fault,omitempty" yaml:"fault,omitempty"`         // how failures look; see the Fault constants
+	HangFor         Duration             `json:"hang_for,omitempty" yaml:"hang_for,omitempty"`   // hang fault limit, 0 = until the client gives up
+	CutAfter        int64                `json:"cut_after,omitempty" yaml:"cut_after,omitempty"` // close_body cut point in bytes, 0 = half the body
+	Mock            *MockSpec            `json:"mock,omitempty" yaml:"mock,omitempty"`           // canned response, the backend is never contacted
+	Throttle        *ThrottleSpec        `json:"throttle,omitempty" yaml:"throttle,omitempty"`
+	Corrupt         *CorruptSpec         `json:"corrupt,omitempty" yaml:"corrupt,omitempty"`
+	ResponseHeaders *ResponseHeadersSpec `json:"response_headers,omitempty" yaml:"response_headers,omitempty"`
+	UpstreamTimeout *UpstreamTimeoutSpec `json:"upstream_timeout,omitempty" yaml:"upstream_timeout,omitempty"`
+	RateLimit       *RateLimitSpec       `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	Request         *RequestSpec         `json:"request,omitempty" yaml:"request,omitempty"`   // drop, duplicate or mutate the upstream request
+	Schedule        *ScheduleSpec        `json:"schedule,omitempty" yaml:"schedule,omitempty"` // active window and ramps, from when the rule went live
+
+	// Paused rules never match; PausedUntil (admin API only) ends the pause automatically
+	Paused      bool      `json:"paused,omitempty" yaml:"paused,omitempty"`
+	PausedUntil time.Time `json:"paused_until,omitzero" yaml:"-"`
+
+	path  *pathPattern    // compiled Path, set by Validate
+	match *requestMatcher // compiled Match, set by Validate
+	state *ruleState      // runtime state, reset by Validate
+	// when the rule was published to the store; schedules start here
+	activated time.Time
+}
+
+// ruleState is runtime state shared by every request hitting a rule. It
+// survives pause/resume but starts fresh when a rule is replaced or reloaded.
+type ruleState struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	counts  map[string]int64 // sequence request counters
+	flap    *flapping
+}
+
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// FailureResponse is one weighted choice for injected failures
+type FailureResponse struct {
+	Weight      float64           `json:"weight,omitempty" yaml:"weight,omitempty"` // relative weight, default 1
+	Status      int               `json:"status" yaml:"status"`
+	Body        string            `json:"body,omitempty" yaml:"body,omitempty"`
+	ContentType string            `json:"content_type,omitempty" yaml:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+func (f *FailureResponse) weight() float64 {
+	if f.Weight == 0 {
+		return 1
+	}
+	return f.Weight
+}
+
+// Normalize trims and upper-cases fields that are matched case-insensitively
+func (r *ChaosRule) Normalize() {
+	r.ID = strings.TrimSpace(r.ID)
+	r.Path = strings.TrimSpace(r.Path)
+	r.Method = strings.ToUpper(strings.TrimSpace(r.Method))
+	r.Fault = strings.ToLower(strings.TrimSpace(r.Fault))
+	if r.Latency != nil {
+		r.Latency.Distribution = strings.ToLower(strings.TrimSpace(r.Latency.Distribution))
+	}
+	if r.Corrupt != nil {
+		r.Corrupt.Mode = strings.ToLower(strings.TrimSpace(r.Corrupt.Mode))
+	}
+	if r.Sequence != nil {
+		r.Sequence.Pattern = strings.ToLower(strings.TrimSpace(r.Sequence.Pattern))
+	}
+	if r.Flap != nil {
+		r.Flap.Start = strings.ToLower(strings.TrimSpace(r.Flap.Start))
+	}
+}
+
+// Validate reports every problem with the rule joined into a single error.
+// It also compiles patterns and resets runtime state, so rules must be
+// validated before use.
+func (r *ChaosRule) Validate() error {
+	var errs []error
+	if p, err := compilePathPattern(r.Path); err != nil {
+		errs = append(errs, err)
+	} else {
+		r.path = p
+	}
+	r.match = nil
+	if r.Match != nil {
+		m, err := r.Match.compile()
+		errs = append(errs, splitErrors(err)...)
+		r.match = m
+	}
+	if r.Cohort != nil {
+		errs = append(errs, splitErrors(r.Cohort.Validate())...)
+	}
+	if r.Method != "" && !knownMethods[r.Method] {
+		errs = append(errs, fmt.Errorf("unknown method %q", r.Method))
+	}
+	if r.Delay < 0 {
+		errs = append(errs, fmt.Errorf("delay must not be negative, got %s", r.Delay))
+	}
+	if r.Latency != nil {
+		if r.Delay != 0 {
+			errs = append(errs, errors.New("use either delay or latency, not both"))
+		}
+		errs = append(errs, splitErrors(r.Latency.Validate())...)
+	}
+	if r.FailureRate < 0 || r.FailureRate > 1 {
+		errs = append(errs, fmt.Errorf("failure_rate must be between 0.0 and 1.0, got %g", r.FailureRate))
+	}
+	if r.Sequence != nil {
+		if r.FailureRate != 0 {
+			errs = append(errs, errors.New("use either failure_rate or sequence, not both"))
+		}
+		errs = append(errs, splitErrors(r.Sequence.Validate())...)
+	}
+	if r.Flap != nil {
+		if r.FailureRate != 0 || r.Sequence != nil {
+			errs = append(errs, errors.New("use only one of failure_rate, sequence and flap"))
+		}
+		errs = append(errs, splitErrors(r.Flap.Validate())...)
+	}
+	if r.StatusCode != 0 && (r.StatusCode < 100 || r.StatusCode > 599) {
+		errs = append(errs, fmt.Errorf("status_code must be between 100 and 599, got %d", r.StatusCode))
+	}
+	if len(r.Responses) > 0 && (r.StatusCode != 0 || r.ErrorBody != "") {
+		errs = append(errs, errors.New("use either responses or status_code/error_body, not both"))
+	}
+	for i, resp := range r.Responses {
+		if resp.Status < 100 || resp.Status > 599 {
+			errs = append(errs, fmt.Errorf("responses[%d].status must be between 100 and 599, got %d", i, resp.Status))
+		}
+		if resp.Weight < 0 {
+			errs = append(errs, fmt.Errorf("responses[%d].weight must not be negative, got %g", i, resp.Weight))
+		}
+	}
+	if r.Fault != "" && !knownFaults[r.Fault] {
+		errs = append(errs, fmt.Errorf("unknown fault %q (use status, reset, close_headers, close_body or hang)", r.Fault))
+	}
+	if r.HangFor < 0 {
+		errs = append(errs, fmt.Errorf("hang_for must not be negative, got %s", r.HangFor))
+	}
+	if r.CutAfter < 0 {
+		errs = append(errs, fmt.Errorf("cut_after must not be negative, got %d", r.CutAfter))
+	}
+	if r.Mock != nil {
+		if r.Throttle != nil || r.Corrupt != nil || r.ResponseHeaders != nil || r.UpstreamTimeout != nil || r.Request != nil || r.Fault == FaultCloseBody {
+			errs = append(errs, errors.New("mock cannot be combined with throttle, corrupt, response_headers, upstream_timeout, request or the close_body fault"))
+		}
+		errs = append(errs, splitErrors(r.Mock.Validate())...)
+	}
+	if r.Throttle != nil {
+		errs = append(errs, splitErrors(r.Throttle.Validate())...)
+	}
+	if r.Corrupt != nil {
+		errs = append(errs, splitErrors(r.Corrupt.Validate())...)
+	}
+	if r.ResponseHeaders != nil {
+		errs = append(errs, splitErrors(r.ResponseHeaders.Validate())...)
+	}
+	if r.UpstreamTimeout != nil {
+		errs = append(errs, splitErrors(r.UpstreamTimeout.Validate())...)
+	}
+	if r.RateLimit != nil {
+		errs = append(errs, splitErrors(r.RateLimit.Validate())...)
+	}
+	if r.Request != nil {
+		errs = append(errs, splitErrors(r.Request.Validate())...)
+	}
+	if r.Schedule != nil {
+		errs = append(errs, splitErrors(r.Schedule.Validate())...)
+	}
+	for name := range r.Headers {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, errors.New("headers must not contain an empty name"))
+		}
+	}
+	r.state = &ruleState{}
+	return errors.Join(errs...)
+}
+
+// matches reports whether the request is targeted by the rule (ignoring pauses)
+func (r *ChaosRule) matches(req *matchRequest) bool {
+	if r.Method != "" && r.Method != req.r.Method {
+		return false
+	}
+	return r.path.match(req.r.URL.Path) && r.match.match(req)
+}
+
+// outranks reports whether r should win over other when both match: the more
+// specific path first, then the rule with more match conditions
+func (r *ChaosRule) outranks(other *ChaosRule) bool {
+	if r.path.kind != other.path.kind || r.path.score != other.path.score {
+		return r.path.outranks(other.path)
+	}
+	return r.match.conditions() > other.match.conditions()
+}
+
+// IsPaused reports whether the rule is paused at the given time
+func (r *ChaosRule) IsPaused(now time.Time) bool {
+	return r.Paused && (r.PausedUntil.IsZero() || now.Before(r.PausedUntil))
+}
+
+// elapsed is the schedule time: since the rule was published, or since the
+// proxy started for rules loaded before that
+func (r *ChaosRule) elapsed(now, started time.Time) time.Duration {
+	if r.activated.After(started) {
+		return now.Sub(r.activated)
+	}
+	return now.Sub(started)
+}
+
+// active reports whether the rule applies at elapsed schedule time
+func (r *ChaosRule) active(now time.Time, elapsed time.Duration) bool {
+	return !r.IsPaused(now) && (r.Schedule == nil || r.Schedule.active(elapsed))
+}
+
+// intensity is the 0..1 scale for failure rate and delay at elapsed
+func (r *ChaosRule) intensity(elapsed time.Duration) float64 {
+	if r.Schedule == nil {
+		return 1
+	}
+	return r.Schedule.intensity(elapsed)
+}
+
+// HasChaos reports whether the rule injects anything at all
+func (r *ChaosRule) HasChaos() bool {
+	return r.Delay > 0 || r.Latency != nil || r.FailureRate > 0 || r.Sequence != nil || r.Flap != nil || r.Mock != nil || r.Throttle != nil || r.Corrupt != nil || r.ResponseHeaders != nil || r.UpstreamTimeout != nil || r.RateLimit != nil || r.Request != nil
+}
+
+// failureStatus is the status code used for injected failures
+func (r *ChaosRule) failureStatus() int {
+	if r.StatusCode == 0 {
+		return http.StatusServiceUnavailable
+	}
+	return r.StatusCode
+}
+
+// failure decides whether a request fails and with which fault ("" is the
+// default status response): by count, by flapping phase or by chance
+func (r *ChaosRule) failure(req *http.Request, rng *Rand, intensity float64) (string, bool) {
+	if r.Sequence != nil {
+		step := r.Sequence.step(r.state.next(clientKey(req, r.Sequence.Key)))
+		if step == "" {
+			return "", false
+		}
+		if step == stepFail {
+			return r.Fault, true
+		}
+		return step, true
+	}
+	if r.Flap != nil {
+		if !r.state.flapDown(r.Flap, time.Now(), rng) {
+			return "", false
+		}
+		rate := r.Flap.Rate
+		if rate == 0 {
+			rate = 1
+		}
+		return r.Fault, rng.Float64() < rate*intensity
+	}
+	if r.FailureRate > 0 && rng.Float64() < r.FailureRate*intensity {
+		return r.Fault, true
+	}
+	return "", false
+}
+
+// sampleDelay returns the delay to inject into one request, scaled by intensity
+func (r *ChaosRule) sampleDelay(rng *Rand, intensity float64) time.Duration {
+	d := time.Duration(r.Delay)
+	if r.Latency != nil {
+		d = r.Latency.Sample(rng)
+	}
+	return time.Duration(float64(d) * intensity)
 }
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ScheduleSpec limits a rule to a time window measured from when the rule
+// went live (proxy start, or when it was added, updated or reloaded) and
+// ramps its intensity up and down inside that window. Intensity scales
+// failure_rate and the injected delay; the other chaos only follows the
+// window. Steps > 0 moves in that many discrete steps instead of linearly.
+type ScheduleSpec struct {
+	StartAfter Duration `json:"start_after,omitempty" yaml:"start_after,omitempty"`
+	Duration   Duration `json:"duration,omitempty" yaml:"duration,omitempty"` // 0 = until the proxy stops
+	RampUp     Duration `json:"ramp_up,omitempty" yaml:"ramp_up,omitempty"`
+	RampDown   Duration `json:"ramp_down,omitempty" yaml:"ramp_down,omitempty"` // at the end of Duration
+	Steps      int      `json:"steps,omitempty" yaml:"steps,omitempty"`
+}
+
+// Validate reports invalid schedule settings
+func (s *ScheduleSpec) Validate() error {
+	var errs []error
+	for _, f := range []struct {
+		name string
+		d    Duration
+	}{{"start_after", s.StartAfter}, {"duration", s.Duration}, {"ramp_up", s.RampUp}, {"ramp_down", s.RampDown}} {
+		if f.d < 0 {
+			errs = append(errs, fmt.Errorf("schedule.%s must not be negative, got %s", f.name, f.d))
+		}
+	}
+	if s.Steps < 0 {
+		errs = append(errs, fmt.Errorf("schedule.steps must not be negative, got %d", s.Steps))
+	}
+	if s.RampDown > 0 && s.Duration == 0 {
+		errs = append(errs, errors.New("schedule.ramp_down needs schedule.duration"))
+	}
+	if s.Duration > 0 && s.RampUp+s.RampDown > s.Duration {
+		errs = append(errs, fmt.Errorf("schedule.ramp_up plus ramp_down (%s) is longer than schedule.duration (%s)", s.RampUp+s.RampDown, s.Duration))
+	}
+	return errors.Join(errs...)
+}
+
+// active reports whether elapsed (see ChaosRule.elapsed) is inside the window
+func (s *ScheduleSpec) active(elapsed time.Duration) bool {
+	t := elapsed - time.Duration(s.StartAfter)
+	return t >= 0 && (s.Duration == 0 || t < time.Duration(s.Duration))
+}
+
+// intensity returns the 0..1 scale for chaos at elapsed
+func (s *ScheduleSpec) intensity(elapsed time.Duration) float64 {
+	if !s.active(elapsed) {
+		return 0
+	}
+	t := elapsed - time.Duration(s.StartAfter)
+	if up := time.Duration(s.RampUp); t < up {
+		return s.ramp(float64(t) / float64(up))
+	}
+	if down := time.Duration(s.RampDown); down > 0 {
+		if left := time.Duration(s.Duration) - t; left < down {
+			return s.ramp(float64(left) / float64(down))
+		}
+	}
+	return 1
+}
+
+// ramp turns a linear intensity into Steps discrete levels, rounding up so a
+// ramp starts moving immediately and ramps down from full strength
+func (s *ScheduleSpec) ramp(x float64) float64 {
+	if s.Steps == 0 {
+		return x
+	}
+	steps := float64(s.Steps)
+	return math.Ceil(x*steps) / steps
+}
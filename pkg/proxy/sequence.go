@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxSequenceKeys bounds per-client counters; beyond it an arbitrary client
+// starts counting again from 1
+const maxSequenceKeys = 10000
+
+// Pattern steps besides the fault kinds
+const (
+	stepOK      = "ok"      // forward the request untouched
+	stepFail    = "fail"    // the rule's own fault
+	stepTimeout = "timeout" // alias for the hang fault
+)
+
+// SequenceSpec fails requests by count instead of by chance. Requests are
+// numbered from 1 per rule, or per client when Key is set. Use one of:
+//
+//	requests  request numbers to fail, e.g. "3-5" or "1,4,10-" (10 onwards)
+//	every     fail every Kth request
+//	pattern   one step per request, repeating, e.g. "ok,ok,fail,timeout";
+//	          steps are ok, fail (the rule's fault), timeout (hang) or a fault kind
+type SequenceSpec struct {
+	Requests string `json:"requests,omitempty" yaml:"requests,omitempty"`
+	Every    int64  `json:"every,omitempty" yaml:"every,omitempty"`
+	Pattern  string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Key      string `json:"key,omitempty" yaml:"key,omitempty"` // see clientKey
+
+	ranges []countRange // compiled Requests
+	steps  []string     // compiled Pattern
+}
+
+type countRange struct{ from, to int64 }
+
+// Validate reports invalid sequence settings and compiles Requests and Pattern
+func (s *SequenceSpec) Validate() error {
+	var errs []error
+	set := 0
+	for _, ok := range []bool{s.Requests != "", s.Every != 0, s.Pattern != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		errs = append(errs, errors.New("sequence needs exactly one of requests, every or pattern"))
+	}
+	if s.Every < 0 {
+		errs = append(errs, fmt.Errorf("sequence.every must be greater than 0, got %d", s.Every))
+	}
+
+	s.ranges = nil
+	if s.Requests != "" {
+		ranges, err := parseCountRanges(s.Requests)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sequence.requests: %w", err))
+		}
+		s.ranges = ranges
+	}
+
+	s.steps = nil
+	if s.Pattern != "" {
+		for _, step := range strings.Split(s.Pattern, ",") {
+			step = strings.TrimSpace(step)
+			switch {
+			case step == stepOK, step == stepFail:
+			case step == stepTimeout:
+				step = FaultHang
+			case knownFaults[step]:
+			default:
+				errs = append(errs, fmt.Errorf("sequence.pattern: unknown step %q (use ok, fail, timeout or a fault kind)", step))
+			}
+			s.steps = append(s.steps, step)
+		}
+	}
+
+	if err := validateClientKey(s.Key); err != nil {
+		errs = append(errs, fmt.Errorf("sequence.key: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// parseCountRanges parses "3", "3-5", "10-" and comma-separated lists of them
+func parseCountRanges(spec string) ([]countRange, error) {
+	var ranges []countRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := strconv.ParseInt(strings.TrimSpace(lo), 10, 64)
+		if err != nil || from < 1 {
+			return nil, fmt.Errorf("invalid request number in %q, numbering starts at 1", part)
+		}
+		to := from
+		if isRange {
+			to = math.MaxInt64
+			if hi = strings.TrimSpace(hi); hi != "" {
+				if to, err = strconv.ParseInt(hi, 10, 64); err != nil || to < from {
+					return nil, fmt.Errorf("invalid range %q", part)
+				}
+			}
+		}
+		ranges = append(ranges, countRange{from, to})
+	}
+	return ranges, nil
+}
+
+// step returns what happens to the nth request: "" to pass it through,
+// stepFail for the rule's fault, or a fault kind
+func (s *SequenceSpec) step(n int64) string {
+	switch {
+	case s.Every > 0:
+		if n%s.Every == 0 {
+			return stepFail
+		}
+	case len(s.steps) > 0:
+		if step := s.steps[(n-1)%int64(len(s.steps))]; step != stepOK {
+			return step
+		}
+	default:
+		for _, r := range s.ranges {
+			if n >= r.from && n <= r.to {
+				return stepFail
+			}
+		}
+	}
+	return ""
+}
+
+// next counts a request for key and returns its number, starting at 1
+func (s *ruleState) next(key string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int64)
+	}
+	if _, ok := s.counts[key]; !ok && len(s.counts) >= maxSequenceKeys {
+		for k := range s.counts {
+			delete(s.counts, k)
+			break
+		}
+	}
+	s.counts[key]++
+	return s.counts[key]
+}
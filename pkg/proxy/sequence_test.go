@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestParseCountRanges(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []countRange
+	}{
+		{"3", []countRange{{3, 3}}},
+		{"1-3", []countRange{{1, 3}}},
+		{"5-", []countRange{{5, math.MaxInt64}}},
+		{"1, 4-6 ,10-", []countRange{{1, 1}, {4, 6}, {10, math.MaxInt64}}},
+		{"2-2", []countRange{{2, 2}}},
+	}
+	for _, tt := range tests {
+		got, err := parseCountRanges(tt.spec)
+		if err != nil {
+			t.Errorf("parseCountRanges(%q): %v", tt.spec, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseCountRanges(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseCountRangesErrors(t *testing.T) {
+	for _, spec := range []string{"", "0", "-3", "a", "3-1", "1-x", "1,,2", "1.5"} {
+		if got, err := parseCountRanges(spec); err == nil {
+			t.Errorf("parseCountRanges(%q) = %v, expected an error", spec, got)
+		}
+	}
+}
+
+func TestSequenceStep(t *testing.T) {
+	tests := []struct {
+		spec SequenceSpec
+		want []string // steps for requests 1..len(want)
+	}{
+		{SequenceSpec{Requests: "2,4-5"}, []string{"", stepFail, "", stepFail, stepFail, ""}},
+		{SequenceSpec{Every: 3}, []string{"", "", stepFail, "", "", stepFail}},
+		{SequenceSpec{Pattern: "ok,fail,timeout"}, []string{"", stepFail, FaultHang, "", stepFail, FaultHang}},
+	}
+	for _, tt := range tests {
+		if err := tt.spec.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range tt.want {
+			if got := tt.spec.step(int64(i + 1)); got != want {
+				t.Errorf("%+v: request %d = %q, want %q", tt.spec, i+1, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRuleNotFound = errors.New("rule not found")
+	ErrRuleExists   = errors.New("rule already exists")
+)
+
+// RuleStore holds the active chaos rules and is safe for concurrent use.
+// Stored rules are never modified in place: every change publishes a new
+// slice, so a snapshot taken by ServeHTTP stays valid for the whole request.
+type RuleStore struct {
+	mu       sync.RWMutex
+	rules    []*ChaosRule
+	version  int
+	nextID   int
+	onChange func(RuleSetChange)
+}
+
+// RuleSetChange describes a published change to the rule set
+type RuleSetChange struct {
+	Version int
+	Op      string // "replace", "add", "update", "delete", "pause" or "resume"
+	Source  string // what triggered a replace, or the ID of the changed rule
+	Rules   []*ChaosRule
+}
+
+// OnChange registers fn to be called after every change. fn runs with the
+// store locked, so calls are serialized and must not call back into the store.
+func (s *RuleStore) OnChange(fn func(RuleSetChange)) {
+	s.mu.Lock()
+	s.onChange = fn
+	s.mu.Unlock()
+}
+
+// NewRuleStore validates rules and returns a store holding them
+func NewRuleStore(rules []ChaosRule) (*RuleStore, error) {
+	s := &RuleStore{}
+	if _, err := s.Replace(rules, "startup"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Snapshot returns the current rules in match order and the rule set version
+func (s *RuleStore) Snapshot() ([]*ChaosRule, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rules, s.version
+}
+
+// Version returns the rule set version, bumped on every change
+func (s *RuleStore) Version() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// List returns copies of all rules
+func (s *RuleStore) List() []ChaosRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ChaosRule, len(s.rules))
+	for i, r := range s.rules {
+		out[i] = *r
+	}
+	return out
+}
+
+// Get returns a copy of the rule with the given ID
+func (s *RuleStore) Get(id string) (ChaosRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return ChaosRule{}, fmt.Errorf("%w: %q", ErrRuleNotFound, id)
+	}
+	return *s.rules[i], nil
+}
+
+// Replace swaps the whole rule set atomically and returns the new version.
+// Invalid rule sets are rejected and the current rules are kept.
+// source describes where the rules came from, e.g. a file path.
+func (s *RuleStore) Replace(rules []ChaosRule, source string) (int, error) {
+	rules = append([]ChaosRule(nil), rules...)
+	if err := PrepareRules(rules); err != nil {
+		return 0, err
+	}
+	next := make([]*ChaosRule, len(rules))
+	for i := range rules {
+		next[i] = &rules[i]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// rules a reload leaves unchanged keep their schedule clock, buckets,
+	// counts and flap cycle
+	for _, r := range next {
+		if i := s.indexOf(r.ID); i >= 0 && sameRule(s.rules[i], r) {
+			r.activated = s.rules[i].activated
+			r.state = s.rules[i].state
+		}
+	}
+	s.nextID = len(next)
+	s.publish("replace", source, next)
+	return s.version, nil
+}
+
+// Add validates a rule and appends it, generating an ID when empty
+func (s *RuleStore) Add(rule ChaosRule) (ChaosRule, error) {
+	rule.Normalize()
+	if err := rule.Validate(); err != nil {
+		return ChaosRule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rule.ID == "" {
+		for rule.ID == "" || s.indexOf(rule.ID) >= 0 {
+			s.nextID++
+			rule.ID = fmt.Sprintf("rule-%d", s.nextID)
+		}
+	} else if s.indexOf(rule.ID) >= 0 {
+		return ChaosRule{}, fmt.Errorf("%w: %q", ErrRuleExists, rule.ID)
+	}
+	s.publish("add", rule.ID, append(s.cloneRules(), &rule))
+	return rule, nil
+}
+
+// Update replaces the rule with the given ID, keeping its position
+func (s *RuleStore) Update(id string, rule ChaosRule) (ChaosRule, error) {
+	rule.Normalize()
+	if rule.ID == "" {
+		rule.ID = id
+	}
+	if err := rule.Validate(); err != nil {
+		return ChaosRule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return ChaosRule{}, fmt.Errorf("%w: %q", ErrRuleNotFound, id)
+	}
+	if rule.ID != id && s.indexOf(rule.ID) >= 0 {
+		return ChaosRule{}, fmt.Errorf("%w: %q", ErrRuleExists, rule.ID)
+	}
+	next := s.cloneRules()
+	next[i] = &rule
+	s.publish("update", id, next)
+	return rule, nil
+}
+
+// Delete removes the rule with the given ID
+func (s *RuleStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrRuleNotFound, id)
+	}
+	next := s.cloneRules()
+	next = append(next[:i], next[i+1:]...)
+	s.publish("delete", id, next)
+	return nil
+}
+
+// Pause stops a rule from matching for d, or until resumed when d is 0
+func (s *RuleStore) Pause(id string, d time.Duration) (ChaosRule, error) {
+	return s.modify(id, "pause", func(r *ChaosRule) {
+		r.Paused = true
+		r.PausedUntil = time.Time{}
+		if d > 0 {
+			r.PausedUntil = time.Now().Add(d)
+		}
+	})
+}
+
+// Resume re-enables a paused rule
+func (s *RuleStore) Resume(id string) (ChaosRule, error) {
+	return s.modify(id, "resume", func(r *ChaosRule) {
+		r.Paused = false
+		r.PausedUntil = time.Time{}
+	})
+}
+
+func (s *RuleStore) modify(id, op string, fn func(r *ChaosRule)) (ChaosRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return ChaosRule{}, fmt.Errorf("%w: %q", ErrRuleNotFound, id)
+	}
+	rule := *s.rules[i]
+	fn(&rule)
+	next := s.cloneRules()
+	next[i] = &rule
+	s.publish(op, id, next)
+	return rule, nil
+}
+
+// indexOf must be called with mu held
+func (s *RuleStore) indexOf(id string) int {
+	for i, r := range s.rules {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// cloneRules must be called with mu held
+func (s *RuleStore) cloneRules() []*ChaosRule {
+	return append(make([]*ChaosRule, 0, len(s.rules)+1), s.rules...)
+}
+
+// sameRule reports whether two rules have the same definition
+func sameRule(a, b *ChaosRule) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// publish must be called with mu held. Rules new to the store (added,
+// updated or replaced) are stamped with the time they go live.
+func (s *RuleStore) publish(op, source string, rules []*ChaosRule) {
+	now := time.Now()
+	for _, r := range rules {
+		if r.activated.IsZero() {
+			r.activated = now
+		}
+	}
+	s.rules = rules
+	s.version++
+	if s.onChange != nil {
+		s.onChange(RuleSetChange{Version: s.version, Op: op, Source: source, Rules: rules})
+	}
+}
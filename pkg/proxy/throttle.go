@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ThrottleSpec limits throughput and drips bodies in small chunks. Response
+// limits apply to what the client receives; RequestBytesPerSecond slows the
+// upload to the backend. Rate is the fraction of matching requests that are
+// throttled (0 means all of them).
+type ThrottleSpec struct {
+	BytesPerSecond        int64    `json:"bytes_per_second,omitempty" yaml:"bytes_per_second,omitempty"`
+	RequestBytesPerSecond int64    `json:"request_bytes_per_second,omitempty" yaml:"request_bytes_per_second,omitempty"`
+	ChunkSize             int      `json:"chunk_size,omitempty" yaml:"chunk_size,omitempty"`
+	ChunkDelay            Duration `json:"chunk_delay,omitempty" yaml:"chunk_delay,omitempty"`
+	Rate                  float64  `json:"rate,omitempty" yaml:"rate,omitempty"`
+}
+
+// Validate reports invalid throttle settings
+func (t *ThrottleSpec) Validate() error {
+	var errs []error
+	if t.BytesPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("throttle.bytes_per_second must not be negative, got %d", t.BytesPerSecond))
+	}
+	if t.RequestBytesPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("throttle.request_bytes_per_second must not be negative, got %d", t.RequestBytesPerSecond))
+	}
+	if t.ChunkSize < 0 {
+		errs = append(errs, fmt.Errorf("throttle.chunk_size must not be negative, got %d", t.ChunkSize))
+	}
+	if t.ChunkDelay < 0 {
+		errs = append(errs, fmt.Errorf("throttle.chunk_delay must not be negative, got %s", t.ChunkDelay))
+	}
+	if t.Rate < 0 || t.Rate > 1 {
+		errs = append(errs, fmt.Errorf("throttle.rate must be between 0.0 and 1.0, got %g", t.Rate))
+	}
+	if t.BytesPerSecond == 0 && t.RequestBytesPerSecond == 0 && t.ChunkSize == 0 && t.ChunkDelay == 0 {
+		errs = append(errs, errors.New("throttle needs bytes_per_second, request_bytes_per_second, chunk_size or chunk_delay"))
+	}
+	return errors.Join(errs...)
+}
+
+// chunkSize picks how many bytes to send between pauses: the configured size,
+// else a tenth of a second's worth, else everything at once
+func (t *ThrottleSpec) chunkSize(bps int64) int {
+	if t.ChunkSize > 0 {
+		return t.ChunkSize
+	}
+	if bps > 0 {
+		return int(max(bps/10, 1))
+	}
+	return 32 * 1024
+}
+
+// pacer spaces out chunks so the total sent never runs ahead of bps, with
+// delay between consecutive chunks
+type pacer struct {
+	ctx   context.Context
+	bps   int64
+	delay time.Duration
+	start time.Time
+	sent  int64
+}
+
+func newPacer(ctx context.Context, bps int64, delay time.Duration) *pacer {
+	return &pacer{ctx: ctx, bps: bps, delay: delay, start: time.Now()}
+}
+
+// wait blocks until the next chunk may go out; it fails once the request is cancelled
+func (p *pacer) wait() error {
+	if p.sent == 0 {
+		return nil
+	}
+	d := p.delay
+	if p.bps > 0 {
+		due := p.start.Add(time.Duration(float64(p.sent) / float64(p.bps) * float64(time.Second)))
+		d = max(d, time.Until(due))
+	}
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// throttleWriter drips the response body to the client
+type throttleWriter struct {
+	http.ResponseWriter
+	pacer *pacer
+	chunk int
+}
+
+func newThrottleWriter(w http.ResponseWriter, r *http.Request, t *ThrottleSpec) *throttleWriter {
+	return &throttleWriter{
+		ResponseWriter: w,
+		pacer:          newPacer(r.Context(), t.BytesPerSecond, time.Duration(t.ChunkDelay)),
+		chunk:          t.chunkSize(t.BytesPerSecond),
+	}
+}
+
+func (t *throttleWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), t.chunk)
+		if err := t.pacer.wait(); err != nil {
+			return written, err
+		}
+		m, err := t.ResponseWriter.Write(b[:n])
+		written += m
+		t.pacer.sent += int64(m)
+		if err != nil {
+			return written, err
+		}
+		_ = http.NewResponseController(t.ResponseWriter).Flush()
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (t *throttleWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// throttleReader slows reading of the request body
+type throttleReader struct {
+	io.ReadCloser
+	pacer *pacer
+	chunk int
+}
+
+func (t *throttleReader) Read(b []byte) (int, error) {
+	if len(b) > t.chunk {
+		b = b[:t.chunk]
+	}
+	if err := t.pacer.wait(); err != nil {
+		return 0, err
+	}
+	n, err := t.ReadCloser.Read(b)
+	t.pacer.sent += int64(n)
+	return n, err
+}
+
+// throttleRequest wraps the request body when an upload limit is set
+func throttleRequest(r *http.Request, t *ThrottleSpec) {
+	if t.RequestBytesPerSecond <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	r.Body = &throttleReader{
+		ReadCloser: r.Body,
+		pacer:      newPacer(r.Context(), t.RequestBytesPerSecond, 0),
+		chunk:      t.chunkSize(t.RequestBytesPerSecond),
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// errUpstreamTimeout is the cancel cause when a rule's upstream timeout fires
+var errUpstreamTimeout = errors.New("chaos: upstream timeout")
+
+// UpstreamTimeoutSpec gives up on the backend like a gateway would: when no
+// response headers arrive within After, the client gets Status (default 504)
+// and Body instead.
+type UpstreamTimeoutSpec struct {
+	After  Duration `json:"after" yaml:"after"`
+	Status int      `json:"status,omitempty" yaml:"status,omitempty"`
+	Body   string   `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// Validate reports invalid timeout settings
+func (t *UpstreamTimeoutSpec) Validate() error {
+	var errs []error
+	if t.After <= 0 {
+		errs = append(errs, fmt.Errorf("upstream_timeout.after must be greater than 0, got %s", t.After))
+	}
+	if t.Status != 0 && (t.Status < 100 || t.Status > 599) {
+		errs = append(errs, fmt.Errorf("upstream_timeout.status must be between 100 and 599, got %d", t.Status))
+	}
+	return errors.Join(errs...)
+}
+
+// start cancels the outgoing request with errUpstreamTimeout after After;
+// stopping the timer once response headers arrive lets the body take its time
+func (t *UpstreamTimeoutSpec) start(cancel context.CancelCauseFunc) *time.Timer {
+	return time.AfterFunc(time.Duration(t.After), func() { cancel(errUpstreamTimeout) })
+}
+
+// writeGatewayTimeout answers for a backend that did not respond in time
+func writeGatewayTimeout(w http.ResponseWriter, t *UpstreamTimeoutSpec) {
+	status := t.Status
+	if status == 0 {
+		status = http.StatusGatewayTimeout
+	}
+	body := t.Body
+	if body == "" {
+		body = `{"error":"upstream timeout"}`
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+}
+
+// proxyError replaces the reverse proxy's error handler to answer upstream
+// timeouts; other errors keep the default 502
+func (cp *ChaosProxy) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	stopUpstreamTiming(r)
+	rc, _ := r.Context().Value(responseChaosKey{}).(*responseChaos)
+	if rc != nil && rc.timeout != nil && errors.Is(context.Cause(r.Context()), errUpstreamTimeout) {
+		rc.timedOut = true
+		writeGatewayTimeout(w, rc.timeout)
+		return
+	}
+	log.Printf("http: proxy error: %v", err)
+	w.WriteHeader(http.StatusBadGateway)
+}